@@ -0,0 +1,280 @@
+package images3
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+)
+
+// Binary icon format. All numbers are little-endian:
+//
+//	magic      [4]byte  "IMG3"
+//	version    uint8
+//	flags      uint8    bit 0: Raw
+//	colorSpace uint8
+//	size       uint16   icon resolution
+//	imgSize    2 int32  X, Y
+//	trimmed    4 int32  Min.X, Min.Y, Max.X, Max.Y
+//	min, max   6 float32
+//	pathLen    uint32
+//	path       [pathLen]byte
+//	pixels     [3*size*size]float32
+const (
+	binaryMagic   = "IMG3"
+	binaryVersion = 1
+	// Length of the header preceding the path.
+	binaryHeaderLen = 4 + 3 + 2 + 4*2 + 4*4 + 4*6 + 4
+)
+
+// Errors of icon decoding. Test for them with errors.Is.
+var (
+	ErrIconData    = errors.New("images3: invalid icon data")
+	ErrIconVersion = errors.New("images3: unsupported icon data version")
+)
+
+// MarshalBinary encodes the icon into a binary form, which keeps
+// all icon fields and can be stored in a database. It implements
+// the encoding.BinaryMarshaler interface. Icons with pixel data
+// not matching their size (e.g. of func EmptyIcon) are an error.
+func (icon IconT) MarshalBinary() ([]byte, error) {
+	size := icon.size()
+	if len(icon.Pixels) != 3*size*size {
+		return nil, fmt.Errorf("%w: %d pixel values for size %d",
+			ErrInvalidIcon, len(icon.Pixels), size)
+	}
+	if size > MaxIconSize {
+		return nil, fmt.Errorf("%w: size %d", ErrInvalidIcon, size)
+	}
+	data := make([]byte, binaryHeaderLen+len(icon.Path)+4*len(icon.Pixels))
+	copy(data, binaryMagic)
+	data[4] = binaryVersion
+	if icon.Raw {
+		data[5] = 1
+	}
+	data[6] = uint8(icon.ColorSpace)
+	le := binary.LittleEndian
+	le.PutUint16(data[7:], uint16(size))
+	pos := 9
+	for _, v := range []int{icon.ImgSize.X, icon.ImgSize.Y,
+		icon.Trimmed.Min.X, icon.Trimmed.Min.Y,
+		icon.Trimmed.Max.X, icon.Trimmed.Max.Y} {
+		le.PutUint32(data[pos:], uint32(int32(v)))
+		pos += 4
+	}
+	for _, v := range append(icon.Min[:], icon.Max[:]...) {
+		le.PutUint32(data[pos:], math.Float32bits(v))
+		pos += 4
+	}
+	le.PutUint32(data[pos:], uint32(len(icon.Path)))
+	pos += 4
+	pos += copy(data[pos:], icon.Path)
+	for _, v := range icon.Pixels {
+		le.PutUint32(data[pos:], math.Float32bits(v))
+		pos += 4
+	}
+	return data, nil
+}
+
+// UnmarshalBinary decodes an icon encoded by func MarshalBinary.
+// It implements the encoding.BinaryUnmarshaler interface. Data of
+// other versions give ErrIconVersion, and corrupt data ErrIconData.
+func (icon *IconT) UnmarshalBinary(data []byte) error {
+	if len(data) < 5 || string(data[:4]) != binaryMagic {
+		return fmt.Errorf("%w: no header", ErrIconData)
+	}
+	if data[4] != binaryVersion {
+		return fmt.Errorf("%w: %d", ErrIconVersion, data[4])
+	}
+	if len(data) < binaryHeaderLen {
+		return fmt.Errorf("%w: truncated header", ErrIconData)
+	}
+	le := binary.LittleEndian
+	size := int(le.Uint16(data[7:]))
+	// Larger icons are never made, and are not allocated.
+	if size == 0 || size > MaxIconSize {
+		return fmt.Errorf("%w: size %d", ErrIconData, size)
+	}
+	pathLen := le.Uint32(data[binaryHeaderLen-4:])
+	numPixels := 3 * size * size
+	if uint64(len(data)) !=
+		uint64(binaryHeaderLen)+uint64(pathLen)+4*uint64(numPixels) {
+		return fmt.Errorf("%w: length %d", ErrIconData, len(data))
+	}
+
+	var v IconT
+	v.Raw = data[5]&1 != 0
+	v.ColorSpace = ColorSpace(data[6])
+	v.Size = size
+	pos := 9
+	ints := make([]int, 6)
+	for i := range ints {
+		ints[i] = int(int32(le.Uint32(data[pos:])))
+		pos += 4
+	}
+	v.ImgSize = Point{ints[0], ints[1]}
+	v.Trimmed.Min.X, v.Trimmed.Min.Y = ints[2], ints[3]
+	v.Trimmed.Max.X, v.Trimmed.Max.Y = ints[4], ints[5]
+	for i := 0; i < 3; i++ {
+		v.Min[i] = math.Float32frombits(le.Uint32(data[pos:]))
+		v.Max[i] = math.Float32frombits(le.Uint32(data[pos+12:]))
+		pos += 4
+	}
+	pos += 12 + 4
+	v.Path = string(data[pos : pos+int(pathLen)])
+	pos += int(pathLen)
+	v.Pixels = make([]float32, numPixels)
+	for i := range v.Pixels {
+		v.Pixels[i] = math.Float32frombits(le.Uint32(data[pos:]))
+		pos += 4
+	}
+	*icon = v
+	return nil
+}
+
+// JSON icon format version. Version 0 is the default encoding of
+// IconT by package encoding/json, used before the icon implemented
+// json.Marshaler. It is still accepted by func UnmarshalJSON.
+const jsonVersion = 1
+
+// iconJSON is the JSON form of IconT. Pixels are base64 encoded
+// little-endian float32 values.
+type iconJSON struct {
+	Version    int        `json:"version"`
+	Size       int        `json:"size"`
+	ColorSpace ColorSpace `json:"colorSpace"`
+	Raw        bool       `json:"raw,omitempty"`
+	ImgSize    [2]int     `json:"imgSize"`
+	Path       string     `json:"path"`
+	Trimmed    *[4]int    `json:"trimmed,omitempty"`
+	Min        [3]float32 `json:"min"`
+	Max        [3]float32 `json:"max"`
+	Pixels     []byte     `json:"pixels"`
+}
+
+// MarshalJSON encodes the icon into JSON with pixel values in
+// compact base64 form. It implements the json.Marshaler interface.
+func (icon IconT) MarshalJSON() ([]byte, error) {
+	size := icon.size()
+	if len(icon.Pixels) != 3*size*size {
+		return nil, fmt.Errorf("%w: %d pixel values for size %d",
+			ErrInvalidIcon, len(icon.Pixels), size)
+	}
+	v := iconJSON{
+		Version:    jsonVersion,
+		Size:       size,
+		ColorSpace: icon.ColorSpace,
+		Raw:        icon.Raw,
+		ImgSize:    [2]int{icon.ImgSize.X, icon.ImgSize.Y},
+		Path:       icon.Path,
+		Min:        icon.Min,
+		Max:        icon.Max,
+		Pixels:     make([]byte, 4*len(icon.Pixels))}
+	if icon.Trimmed != (image.Rectangle{}) {
+		r := icon.Trimmed
+		v.Trimmed = &[4]int{r.Min.X, r.Min.Y, r.Max.X, r.Max.Y}
+	}
+	for i, c := range icon.Pixels {
+		binary.LittleEndian.PutUint32(v.Pixels[4*i:], math.Float32bits(c))
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON decodes an icon encoded by func MarshalJSON, or
+// by package encoding/json before IconT implemented json.Marshaler.
+// It implements the json.Unmarshaler interface.
+func (icon *IconT) UnmarshalJSON(data []byte) error {
+	// JSON null is a no-op by convention of package encoding/json.
+	if string(data) == "null" {
+		return nil
+	}
+	var header struct {
+		Version *int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return fmt.Errorf("%w: %v", ErrIconData, err)
+	}
+	if header.Version == nil {
+		// Version 0 with fields of IconT. The type conversion
+		// avoids recursion into this method.
+		type legacyIcon IconT
+		var v legacyIcon
+		if err := json.Unmarshal(data, &v); err != nil {
+			return fmt.Errorf("%w: %v", ErrIconData, err)
+		}
+		if size := IconT(v).size(); size > MaxIconSize ||
+			len(v.Pixels) != 3*size*size {
+			return fmt.Errorf("%w: %d pixel values",
+				ErrIconData, len(v.Pixels))
+		}
+		*icon = IconT(v)
+		return nil
+	}
+	if *header.Version != jsonVersion {
+		return fmt.Errorf("%w: %d", ErrIconVersion, *header.Version)
+	}
+
+	var v iconJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("%w: %v", ErrIconData, err)
+	}
+	if v.Size <= 0 || v.Size > MaxIconSize ||
+		len(v.Pixels) != 4*3*v.Size*v.Size {
+		return fmt.Errorf("%w: %d pixel bytes for size %d",
+			ErrIconData, len(v.Pixels), v.Size)
+	}
+	dst := IconT{
+		ImgSize:    Point{v.ImgSize[0], v.ImgSize[1]},
+		Path:       v.Path,
+		Size:       v.Size,
+		ColorSpace: v.ColorSpace,
+		Min:        v.Min,
+		Max:        v.Max,
+		Raw:        v.Raw,
+		Pixels:     make([]float32, 3*v.Size*v.Size)}
+	if r := v.Trimmed; r != nil {
+		dst.Trimmed = image.Rect(r[0], r[1], r[2], r[3])
+	}
+	for i := range dst.Pixels {
+		dst.Pixels[i] = math.Float32frombits(
+			binary.LittleEndian.Uint32(v.Pixels[4*i:]))
+	}
+	*icon = dst
+	return nil
+}
+
+// Hash string format version, which is the first character
+// of hash strings.
+const hashVersion = '1'
+
+// ErrHashFormat is the error of parsing of hash strings.
+var ErrHashFormat = errors.New("images3: invalid hash string")
+
+// FormatHash returns the string form of a hash of func CentralHash
+// or HashSet: a version character followed by 16 lower-case hex
+// digits. Hash strings have a fixed width, sort in the order of
+// hash values and are safe as keys of external key-value stores.
+func FormatHash(hash uint64) string {
+	return fmt.Sprintf("%c%016x", hashVersion, hash)
+}
+
+// ParseHash parses a hash string made by func FormatHash.
+func ParseHash(s string) (uint64, error) {
+	if len(s) != 17 || s[0] != hashVersion {
+		return 0, fmt.Errorf("%w: %q", ErrHashFormat, s)
+	}
+	var hash uint64
+	for _, c := range []byte(s[1:]) {
+		switch {
+		case c >= '0' && c <= '9':
+			hash = hash<<4 | uint64(c-'0')
+		case c >= 'a' && c <= 'f':
+			hash = hash<<4 | uint64(c-'a'+10)
+		default:
+			return 0, fmt.Errorf("%w: %q", ErrHashFormat, s)
+		}
+	}
+	return hash, nil
+}
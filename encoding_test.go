@@ -0,0 +1,235 @@
+package images3
+
+import (
+	"encoding/json"
+	"errors"
+	"image"
+	"math"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestMarshalBinary(t *testing.T) {
+	file := path.Join("testdata", "euclidean", "large.jpg")
+	img, err := Open(file)
+	if err != nil {
+		t.Fatal("Error opening image:", err)
+	}
+	trimmed := IconWithOptions(img, "", IconOptions{Trim: true})
+	trimmed.Trimmed = image.Rect(-3, 4, 500, 600)
+	for name, icon := range map[string]IconT{
+		"default": Icon(img, file),
+		"sized":   IconWithOptions(img, "", IconOptions{Size: 16}),
+		"Lab":     IconWithOptions(img, "фото.jpg", IconOptions{ColorSpace: ColorLab}),
+		"raw":     IconWithOptions(img, file, IconOptions{Raw: true}),
+		"trimmed": trimmed,
+	} {
+		data, err := icon.MarshalBinary()
+		if err != nil {
+			t.Fatalf("%s: error encoding icon: %v", name, err)
+		}
+		var got IconT
+		if err := got.UnmarshalBinary(data); err != nil {
+			t.Fatalf("%s: error decoding icon: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, icon) {
+			t.Errorf("%s: expected %+v, got %+v.", name, icon, got)
+		}
+	}
+
+	// Icons with no recorded size are of the default size.
+	icon := sizedIcon(iconSize)
+	icon.ImgSize = Point{10, 20}
+	data, err := icon.MarshalBinary()
+	if err != nil {
+		t.Fatal("Error encoding icon:", err)
+	}
+	var got IconT
+	if err := got.UnmarshalBinary(data); err != nil || got.Size != iconSize {
+		t.Errorf("Expected size %d, got %d, %v.", iconSize, got.Size, err)
+	}
+
+	if _, err := EmptyIcon().MarshalBinary(); !errors.Is(err, ErrInvalidIcon) {
+		t.Errorf("Expected ErrInvalidIcon for an empty icon, got %v.", err)
+	}
+}
+
+func TestUnmarshalBinaryErrors(t *testing.T) {
+	img, err := Open(path.Join("testdata", "euclidean", "large.jpg"))
+	if err != nil {
+		t.Fatal("Error opening image:", err)
+	}
+	data, err := Icon(img, "large.jpg").MarshalBinary()
+	if err != nil {
+		t.Fatal("Error encoding icon:", err)
+	}
+
+	// Truncated data.
+	for n := 0; n < len(data); n++ {
+		icon := EmptyIcon()
+		if err := icon.UnmarshalBinary(data[:n]); !errors.Is(err, ErrIconData) {
+			t.Fatalf("Expected ErrIconData for %d bytes, got %v.", n, err)
+		}
+		if icon.Pixels != nil {
+			t.Fatal("Expected the icon not to change on error.")
+		}
+	}
+
+	modified := func(i int, b byte) []byte {
+		d := append([]byte{}, data...)
+		d[i] = b
+		return d
+	}
+	var icon IconT
+	for name, c := range map[string]struct {
+		data []byte
+		err  error
+	}{
+		"magic":      {modified(0, 'X'), ErrIconData},
+		"version":    {modified(4, binaryVersion+1), ErrIconVersion},
+		"size":       {modified(7, 12), ErrIconData},
+		"zero size":  {modified(7, 0), ErrIconData},
+		"large size": {modified(7, MaxIconSize+1), ErrIconData},
+		"path":       {modified(binaryHeaderLen-4, 200), ErrIconData},
+		"trailing":   {append(append([]byte{}, data...), 0), ErrIconData},
+	} {
+		if err := icon.UnmarshalBinary(c.data); !errors.Is(err, c.err) {
+			t.Errorf("%s: expected %v, got %v.", name, c.err, err)
+		}
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	file := path.Join("testdata", "euclidean", "large.jpg")
+	img, err := Open(file)
+	if err != nil {
+		t.Fatal("Error opening image:", err)
+	}
+	trimmed := IconWithOptions(img, file, IconOptions{Size: 16, Trim: true})
+	trimmed.Trimmed = image.Rect(1, 2, 300, 400)
+	for name, icon := range map[string]IconT{
+		"default": Icon(img, file),
+		"trimmed": trimmed,
+		"Lab":     IconWithOptions(img, "", IconOptions{ColorSpace: ColorLab}),
+		"raw":     IconWithOptions(img, "", IconOptions{Raw: true}),
+	} {
+		data, err := json.Marshal(icon)
+		if err != nil {
+			t.Fatalf("%s: error encoding icon: %v", name, err)
+		}
+		var got IconT
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("%s: error decoding icon: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, icon) {
+			t.Errorf("%s: expected %+v, got %+v.", name, icon, got)
+		}
+	}
+
+	// Icons as fields and pointers.
+	type record struct {
+		ID   int
+		Icon IconT
+		Ptr  *IconT
+	}
+	icon := Icon(img, file)
+	data, err := json.Marshal(record{7, icon, nil})
+	if err != nil {
+		t.Fatal("Error encoding record:", err)
+	}
+	var r record
+	if err := json.Unmarshal(data, &r); err != nil ||
+		!reflect.DeepEqual(r.Icon, icon) || r.Ptr != nil {
+		t.Errorf("Expected the record to be decoded, got %+v, %v.", r, err)
+	}
+
+	// Base64 pixels are more compact than float literals.
+	legacy, err := json.Marshal(struct {
+		Pixels  []float32
+		ImgSize Point
+		Path    string
+	}{icon.Pixels, icon.ImgSize, icon.Path})
+	if err != nil {
+		t.Fatal("Error encoding legacy icon:", err)
+	}
+	if len(data) >= len(legacy) {
+		t.Errorf("Expected shorter JSON than %d bytes, got %d.",
+			len(legacy), len(data))
+	}
+}
+
+func TestUnmarshalJSONVersions(t *testing.T) {
+	img, err := Open(path.Join("testdata", "euclidean", "large.jpg"))
+	if err != nil {
+		t.Fatal("Error opening image:", err)
+	}
+	icon := Icon(img, "large.jpg")
+
+	// Version 0 is the default encoding of original icon fields.
+	legacy, err := json.Marshal(struct {
+		Pixels  []float32
+		ImgSize Point
+		Path    string
+	}{icon.Pixels, icon.ImgSize, icon.Path})
+	if err != nil {
+		t.Fatal("Error encoding legacy icon:", err)
+	}
+	var got IconT
+	if err := json.Unmarshal(legacy, &got); err != nil {
+		t.Fatal("Error decoding legacy icon:", err)
+	}
+	if !reflect.DeepEqual(got.Pixels, icon.Pixels) ||
+		got.ImgSize != icon.ImgSize || got.Path != icon.Path {
+		t.Errorf("Expected legacy icon fields, got %+v.", got)
+	}
+	if !Similar(got, icon) {
+		t.Error("Expected similarity of the legacy icon.")
+	}
+
+	for name, c := range map[string]struct {
+		data string
+		err  error
+	}{
+		"version":  {`{"version":2,"size":11}`, ErrIconVersion},
+		"pixels":   {`{"version":1,"size":11,"pixels":"AAAA"}`, ErrIconData},
+		"base64":   {`{"version":1,"size":11,"pixels":"#"}`, ErrIconData},
+		"size":     {`{"version":1,"size":0}`, ErrIconData},
+		"legacy":   {`{"Pixels":[1,2,3]}`, ErrIconData},
+		"syntax":   {`{"version":`, ErrIconData},
+		"not icon": {`[1,2,3]`, ErrIconData},
+	} {
+		var icon IconT
+		err := icon.UnmarshalJSON([]byte(c.data))
+		if !errors.Is(err, c.err) {
+			t.Errorf("%s: expected %v, got %v.", name, c.err, err)
+		}
+	}
+}
+
+func TestFormatHash(t *testing.T) {
+	hashes := []uint64{0, 1, 0xabcdef, 1<<63 + 5, math.MaxUint64}
+	for i, hash := range hashes {
+		s := FormatHash(hash)
+		if len(s) != 17 {
+			t.Errorf("Expected 17 characters, got %q.", s)
+		}
+		got, err := ParseHash(s)
+		if err != nil || got != hash {
+			t.Errorf("Expected %v, got %v, %v.", hash, got, err)
+		}
+		if i > 0 && FormatHash(hashes[i-1]) >= s {
+			t.Errorf("Expected %q before %q.", FormatHash(hashes[i-1]), s)
+		}
+	}
+	if s := FormatHash(0xabcdef); s != "10000000000abcdef" {
+		t.Errorf("Expected \"10000000000abcdef\", got %q.", s)
+	}
+	for _, s := range []string{"", "1", "0000000000000000a",
+		"20000000000abcdef", "10000000000ABCDEF", "1000000000000000g",
+		"10000000000abcdef0"} {
+		if _, err := ParseHash(s); !errors.Is(err, ErrHashFormat) {
+			t.Errorf("Expected ErrHashFormat for %q, got %v.", s, err)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package images3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"os"
+)
+
+// FileOptions are parameters of icon generation with func IconFromFile.
+// The zero value produces the same icons as func Icon for images
+// opened with func Open.
+type FileOptions struct {
+	// Icon is icon generation parameters.
+	Icon IconOptions
+	// Thumbnail enables icon generation from EXIF thumbnails of
+	// JPEG files, which is much faster than decoding full images.
+	// A thumbnail is used when it is large enough for the icon
+	// size and has the proportions of the main image. The latter
+	// guards against thumbnails not updated after image editing.
+	Thumbnail bool
+	// Reduce enables reduced-scale decoding of large JPEG files
+	// (see func DecodeForIcon).
+	Reduce bool
+	// Open is image opening parameters. Image size limits apply
+	// to all decoding, including reduced scale decoding.
+	Open OpenOptions
+}
+
+// Minimal number of thumbnail pixels per pixel of the large icon
+// (see func Icon) along each side.
+const thumbSamples = 4
+
+// Maximal proportion difference of a thumbnail and its image
+// (see func PropMetric). Thumbnail sizes are rounded to whole
+// pixels, so the difference is not 0 even for proper thumbnails.
+const thThumbProp = 0.02
+
+// Maximal thumbnail side. EXIF thumbnails are about 160x120 pixels,
+// and larger declared sizes come from corrupt or crafted data.
+const maxThumbSide = 1024
+
+// IconFromFile opens an image file and generates its icon.
+// ImgSize of the icon is the size of the main image, also
+// when the icon is made from a thumbnail or a reduced image.
+// With IconOptions.Trim, ImgSize and Trimmed are the trimmed
+// rectangle size and the rectangle in main image coordinates.
+func IconFromFile(path string, opts FileOptions) (IconT, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return EmptyIcon(), err
+	}
+	size := optionSize(opts.Icon.Size)
+
+	orientation := 1
+	if opts.Open.Orient {
+		orientation = jpegOrientation(data)
+	}
+
+	if opts.Thumbnail {
+		if icon, ok := thumbnailIcon(
+			data, path, size, orientation, opts); ok {
+			return icon, nil
+		}
+	}
+
+	// Orientation is already known and applied below.
+	openOpts := opts.Open
+	openOpts.Orient = false
+	var img image.Image
+	var imgSize Point
+	if opts.Reduce {
+		img, imgSize, err = DecodeForIconWithOptions(
+			bytes.NewReader(data), size, openOpts)
+	} else {
+		img, _, err = DecodeWithOptions(bytes.NewReader(data), openOpts)
+	}
+	if err != nil {
+		return EmptyIcon(), err
+	}
+	img = orient(img, orientation)
+	icon := IconWithOptions(img, path, opts.Icon)
+	if opts.Reduce {
+		scaleToImage(&icon, img.Bounds(), orientSize(imgSize, orientation))
+	}
+	return icon, nil
+}
+
+// scaleToImage sets ImgSize and Trimmed of an icon made from a
+// downscaled copy of an image with bounds src to values of the image
+// of size imgSize. With trimming, ImgSize is the size of the trimmed
+// rectangle scaled to image coordinates.
+func scaleToImage(icon *IconT, src image.Rectangle, imgSize Point) {
+	if icon.Trimmed.Empty() {
+		icon.ImgSize = imgSize
+		return
+	}
+	// Trimmed rectangles are rounded outwards.
+	dx, dy := src.Dx(), src.Dy()
+	r := icon.Trimmed.Sub(src.Min)
+	r.Min.X = r.Min.X * imgSize.X / dx
+	r.Min.Y = r.Min.Y * imgSize.Y / dy
+	r.Max.X = (r.Max.X*imgSize.X + dx - 1) / dx
+	r.Max.Y = (r.Max.Y*imgSize.Y + dy - 1) / dy
+	icon.Trimmed = r.Intersect(image.Rect(0, 0, imgSize.X, imgSize.Y))
+	icon.ImgSize = Point{icon.Trimmed.Dx(), icon.Trimmed.Dy()}
+}
+
+// thumbnailIcon generates an icon from the EXIF thumbnail of JPEG
+// data, if the thumbnail is suitable. The thumbnail is stored in
+// the orientation of the main image, and the same EXIF orientation
+// is applied to it. Thumbnail size is checked before decoding.
+func thumbnailIcon(data []byte, path string, size, orientation int,
+	opts FileOptions) (IconT, bool) {
+	info, err := readJPEGInfo(data)
+	if err != nil || info.exif == nil {
+		return IconT{}, false
+	}
+	ex, err := parseEXIF(info.exif)
+	if err != nil || ex.thumbnail == nil {
+		return IconT{}, false
+	}
+	config, err := jpeg.DecodeConfig(bytes.NewReader(ex.thumbnail))
+	if err != nil || config.Width > maxThumbSide ||
+		config.Height > maxThumbSide ||
+		checkSize(config.Width, config.Height, opts.Open) != nil {
+		return IconT{}, false
+	}
+	minSide := (size*2 + 1) * thumbSamples
+	if config.Width < minSide || config.Height < minSide {
+		return IconT{}, false
+	}
+	if propMetric(Point{config.Width, config.Height},
+		info.size) > thThumbProp {
+		return IconT{}, false
+	}
+	thumb, err := jpeg.Decode(bytes.NewReader(ex.thumbnail))
+	if err != nil {
+		return IconT{}, false
+	}
+	thumb = orient(thumb, orientation)
+	icon := IconWithOptions(thumb, path, opts.Icon)
+	scaleToImage(&icon, thumb.Bounds(), orientSize(info.size, orientation))
+	return icon, true
+}
+
+// orientSize returns image size after applying EXIF orientation.
+func orientSize(size Point, orientation int) Point {
+	if swapsAxes(orientation) {
+		return Point{size.Y, size.X}
+	}
+	return size
+}
+
+// jpegOrientation returns the EXIF orientation tag value of JPEG
+// data. It is 1 (no transformation) for images without the tag.
+func jpegOrientation(data []byte) int {
+	// EXIF data is found also in truncated data with no SOF segment.
+	info, _ := readJPEGInfo(data)
+	if info.exif == nil {
+		return 1
+	}
+	ex, err := parseEXIF(info.exif)
+	if err != nil || ex.orientation == 0 {
+		return 1
+	}
+	return ex.orientation
+}
+
+// jpegInfo is JPEG file data available without decoding.
+type jpegInfo struct {
+	size Point  // Image size from the SOF segment.
+	exif []byte // Payload of the EXIF APP1 segment, or nil.
+}
+
+// readJPEGInfo reads JPEG segments up to the SOF segment.
+func readJPEGInfo(data []byte) (info jpegInfo, err error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != mSOI {
+		return info, errJPEGData
+	}
+	pos := 2
+	for {
+		marker, start, end, err := nextSegment(data, pos)
+		if err != nil {
+			return info, err
+		}
+		seg := data[start:end]
+		switch {
+		case marker == mAPP1 && info.exif == nil &&
+			bytes.HasPrefix(seg, []byte("Exif\x00\x00")):
+			info.exif = seg[6:]
+		case marker >= mSOF0 && marker <= 0xcf && marker != mDHT &&
+			marker != 0xc8 && marker != 0xcc:
+			if len(seg) < 5 {
+				return info, errJPEGData
+			}
+			info.size = Point{
+				int(seg[3])<<8 | int(seg[4]),
+				int(seg[1])<<8 | int(seg[2])}
+			return info, nil
+		case marker == mSOS || marker == mEOI:
+			return info, errJPEGData
+		}
+		pos = end
+	}
+}
+
+// EXIF tags.
+const (
+	tagOrientation = 0x0112
+	tagThumbOffset = 0x0201 // JPEGInterchangeFormat.
+	tagThumbLength = 0x0202 // JPEGInterchangeFormatLength.
+)
+
+var errEXIFData = errors.New("images3: invalid exif data")
+
+// exifData is EXIF information used by the package.
+type exifData struct {
+	orientation int    // Orientation tag value, or 0.
+	thumbnail   []byte // JPEG thumbnail, or nil.
+}
+
+// tiffReader reads TIFF structures of EXIF data.
+type tiffReader struct {
+	data  []byte // Data starting with the TIFF header.
+	order binary.ByteOrder
+}
+
+// ifdEntry is an entry of an image file directory.
+type ifdEntry struct {
+	tag, typ uint16
+	count    uint32
+	value    []byte // The 4-byte value or offset field.
+}
+
+// parseEXIF parses EXIF data following the "Exif\0\0" header
+// of the APP1 segment.
+func parseEXIF(data []byte) (ex exifData, err error) {
+	if len(data) < 8 {
+		return ex, errEXIFData
+	}
+	tr := tiffReader{data: data}
+	switch string(data[:4]) {
+	case "II*\x00":
+		tr.order = binary.LittleEndian
+	case "MM\x00*":
+		tr.order = binary.BigEndian
+	default:
+		return ex, errEXIFData
+	}
+
+	// IFD0 describes the main image.
+	entries, next, err := tr.ifd(tr.order.Uint32(data[4:]))
+	if err != nil {
+		return ex, err
+	}
+	for _, e := range entries {
+		if e.tag == tagOrientation {
+			if v, ok := tr.uint(e); ok && v >= 1 && v <= 8 {
+				ex.orientation = int(v)
+			}
+		}
+	}
+
+	// IFD1 describes the thumbnail.
+	if next == 0 {
+		return ex, nil
+	}
+	entries, _, err = tr.ifd(next)
+	if err != nil {
+		return ex, err
+	}
+	var offset, length uint32
+	for _, e := range entries {
+		switch e.tag {
+		case tagThumbOffset:
+			offset, _ = tr.uint(e)
+		case tagThumbLength:
+			length, _ = tr.uint(e)
+		}
+	}
+	if length > 0 && uint64(offset)+uint64(length) <= uint64(len(data)) {
+		ex.thumbnail = data[offset : offset+length]
+	}
+	return ex, nil
+}
+
+// ifd reads an image file directory at offset. It returns the
+// entries and offset of the next directory (0 if none).
+func (tr tiffReader) ifd(offset uint32) (entries []ifdEntry,
+	next uint32, err error) {
+	if uint64(offset)+2 > uint64(len(tr.data)) {
+		return nil, 0, errEXIFData
+	}
+	n := int(tr.order.Uint16(tr.data[offset:]))
+	pos := int(offset) + 2
+	if pos+12*n+4 > len(tr.data) {
+		return nil, 0, errEXIFData
+	}
+	for i := 0; i < n; i++ {
+		p := tr.data[pos+12*i:]
+		entries = append(entries, ifdEntry{
+			tag:   tr.order.Uint16(p),
+			typ:   tr.order.Uint16(p[2:]),
+			count: tr.order.Uint32(p[4:]),
+			value: p[8:12]})
+	}
+	next = tr.order.Uint32(tr.data[pos+12*n:])
+	// Loops of directories are not followed.
+	if next <= offset {
+		next = 0
+	}
+	return entries, next, nil
+}
+
+// uint reads a single SHORT or LONG value of an entry.
+func (tr tiffReader) uint(e ifdEntry) (uint32, bool) {
+	if e.count != 1 {
+		return 0, false
+	}
+	switch e.typ {
+	case 3: // SHORT.
+		return uint32(tr.order.Uint16(e.value)), true
+	case 4: // LONG.
+		return tr.order.Uint32(e.value), true
+	}
+	return 0, false
+}
@@ -0,0 +1,246 @@
+package images3
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestParseEXIF(t *testing.T) {
+	data, err := os.ReadFile(path.Join("testdata", "exif", "thumbnail.jpg"))
+	if err != nil {
+		t.Fatal("Error reading file:", err)
+	}
+	info, err := readJPEGInfo(data)
+	if err != nil {
+		t.Fatal("Error reading JPEG info:", err)
+	}
+	if info.size != (Point{533, 400}) {
+		t.Errorf("Expected image size (533, 400), got %v.", info.size)
+	}
+	ex, err := parseEXIF(info.exif)
+	if err != nil {
+		t.Fatal("Error parsing EXIF:", err)
+	}
+	thumb, err := readJPEGInfo(ex.thumbnail)
+	if err != nil {
+		t.Fatal("Error reading thumbnail info:", err)
+	}
+	if thumb.size != (Point{160, 120}) {
+		t.Errorf("Expected thumbnail size (160, 120), got %v.", thumb.size)
+	}
+
+	// Corrupt data must not cause panics.
+	for n := 0; n < len(info.exif); n += 7 {
+		parseEXIF(info.exif[:n])
+	}
+	// No EXIF.
+	data, err = os.ReadFile(path.Join("testdata", "euclidean", "large.jpg"))
+	if err != nil {
+		t.Fatal("Error reading file:", err)
+	}
+	info, err = readJPEGInfo(data)
+	if err != nil || info.exif != nil {
+		t.Errorf("Expected no EXIF data, got %v.", err)
+	}
+}
+
+func TestIconFromFileThumbnail(t *testing.T) {
+	dir := path.Join("testdata", "exif")
+	img, err := Open(path.Join(dir, "thumbnail.jpg"))
+	if err != nil {
+		t.Fatal("Error opening image:", err)
+	}
+	full := Icon(img, "")
+
+	// Thumbnail is used.
+	filePath := path.Join(dir, "thumbnail.jpg")
+	icon, err := IconFromFile(filePath, FileOptions{Thumbnail: true})
+	if err != nil {
+		t.Fatal("Error making icon:", err)
+	}
+	if icon.ImgSize != (Point{533, 400}) || icon.Path != filePath {
+		t.Errorf("Expected image size (533, 400) and path %s, got %v, %s.",
+			filePath, icon.ImgSize, icon.Path)
+	}
+	if reflect.DeepEqual(icon.Pixels, full.Pixels) {
+		t.Error("Expected an icon made from the thumbnail.")
+	}
+	if !Similar(icon, full) {
+		t.Error("Expected similarity of thumbnail and image icons.")
+	}
+
+	// Thumbnail is not used without the option, when it has
+	// proportions distinct from the image, and when it is too small.
+	for _, tc := range []struct {
+		name string
+		opts FileOptions
+	}{
+		{"thumbnail.jpg", FileOptions{}},
+		{"thumbnail-stale.jpg", FileOptions{Thumbnail: true}},
+		{"thumbnail-small.jpg", FileOptions{Thumbnail: true}},
+	} {
+		icon, err := IconFromFile(path.Join(dir, tc.name), tc.opts)
+		if err != nil {
+			t.Fatal("Error making icon:", err)
+		}
+		if !reflect.DeepEqual(icon.Pixels, full.Pixels) ||
+			icon.ImgSize != full.ImgSize {
+			t.Errorf("Expected an icon of the full image for %s.", tc.name)
+		}
+	}
+	// Larger icons need larger thumbnails.
+	icon, err = IconFromFile(filePath,
+		FileOptions{Icon: IconOptions{Size: 24}, Thumbnail: true})
+	if err != nil {
+		t.Fatal("Error making icon:", err)
+	}
+	if !reflect.DeepEqual(icon.Pixels,
+		IconWithOptions(img, "", IconOptions{Size: 24}).Pixels) {
+		t.Error("Expected an icon of the full image for size 24.")
+	}
+}
+
+func TestIconFromFileThumbnailSize(t *testing.T) {
+	data, err := os.ReadFile(path.Join("testdata", "exif", "thumbnail.jpg"))
+	if err != nil {
+		t.Fatal("Error reading file:", err)
+	}
+	// The first SOF segment is of the thumbnail, which precedes
+	// the main image. Its declared size is changed to 20000x15000,
+	// which has the proportions of the image.
+	i := bytes.Index(data, []byte{0xff, 0xc0})
+	if i < 0 {
+		t.Fatal("Expected a SOF segment.")
+	}
+	data[i+5], data[i+6] = 15000>>8, 15000&0xff
+	data[i+7], data[i+8] = 20000>>8, 20000&0xff
+	if ex, err := parseEXIF(mustJPEGInfo(t, data).exif); err != nil ||
+		mustJPEGInfo(t, ex.thumbnail).size != (Point{20000, 15000}) {
+		t.Fatal("Expected a thumbnail of size (20000, 15000).")
+	}
+	filePath := path.Join(t.TempDir(), "large-thumbnail.jpg")
+	if err := os.WriteFile(filePath, data, 0644); err != nil {
+		t.Fatal("Error writing file:", err)
+	}
+
+	img, err := Open(filePath)
+	if err != nil {
+		t.Fatal("Error opening image:", err)
+	}
+	full := Icon(img, filePath)
+	// The thumbnail is rejected before decoding, which would
+	// allocate hundreds of megabytes.
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	icon, err := IconFromFile(filePath, FileOptions{Thumbnail: true})
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		t.Fatal("Error making icon:", err)
+	}
+	if !reflect.DeepEqual(icon.Pixels, full.Pixels) {
+		t.Error("Expected an icon of the full image.")
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 1<<26 {
+		t.Errorf("Expected allocation below 64 MB, got %d bytes.", alloc)
+	}
+}
+
+// Trimmed rectangles of icons made from thumbnails and reduced
+// images are in main image coordinates.
+func TestIconFromFileTrim(t *testing.T) {
+	trim := IconOptions{Trim: true}
+	filePath := path.Join("testdata", "exif", "thumbnail.jpg")
+	icon, err := IconFromFile(filePath,
+		FileOptions{Icon: trim, Thumbnail: true})
+	if err != nil {
+		t.Fatal("Error making icon:", err)
+	}
+	want := image.Rect(0, 0, 533, 400)
+	if icon.Trimmed != want || icon.ImgSize != (Point{533, 400}) {
+		t.Errorf("Expected trimmed rectangle %v and image size (533, 400), "+
+			"got %v, %v.", want, icon.Trimmed, icon.ImgSize)
+	}
+
+	// A letterboxed image of reduced decoding.
+	img, err := Open(path.Join("testdata", "euclidean", "large.jpg"))
+	if err != nil {
+		t.Fatal("Error opening image:", err)
+	}
+	large, _, _ := ResizeByArea(img, 3200, 2400)
+	canvas := image.NewGray(image.Rect(0, 0, 3200, 3200))
+	draw.Draw(canvas, large.Bounds().Add(image.Pt(0, 400)),
+		&large, image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, canvas, nil); err != nil {
+		t.Fatal("Error encoding image:", err)
+	}
+	filePath = path.Join(t.TempDir(), "letterbox.jpg")
+	if err := os.WriteFile(filePath, buf.Bytes(), 0644); err != nil {
+		t.Fatal("Error writing file:", err)
+	}
+	full, err := IconFromFile(filePath, FileOptions{Icon: trim})
+	if err != nil {
+		t.Fatal("Error making icon:", err)
+	}
+	reduced, err := IconFromFile(filePath,
+		FileOptions{Icon: trim, Reduce: true})
+	if err != nil {
+		t.Fatal("Error making icon:", err)
+	}
+	want = image.Rect(0, 400, 3200, 2800)
+	// Reduced pixels are 8x8 blocks.
+	if !near(full.Trimmed, want, 8) || !near(reduced.Trimmed, want, 16) {
+		t.Errorf("Expected trimmed rectangles near %v, got %v and %v.",
+			want, full.Trimmed, reduced.Trimmed)
+	}
+	if size := reduced.Trimmed.Size(); reduced.ImgSize != Point(size) {
+		t.Errorf("Expected image size %v, got %v.", size, reduced.ImgSize)
+	}
+}
+
+// mustJPEGInfo is func readJPEGInfo failing the test on errors.
+func mustJPEGInfo(t *testing.T, data []byte) jpegInfo {
+	info, err := readJPEGInfo(data)
+	if err != nil {
+		t.Fatal("Error reading JPEG info:", err)
+	}
+	return info
+}
+
+func TestIconFromFileErrors(t *testing.T) {
+	if _, err := IconFromFile(
+		path.Join("testdata", "missing.jpg"), FileOptions{}); err == nil {
+		t.Error("Expected an error for a missing file.")
+	}
+
+	// Image size limits apply to all decoding paths.
+	var buf bytes.Buffer
+	if err := png.Encode(&buf,
+		image.NewGray(image.Rect(0, 0, 3000, 3000))); err != nil {
+		t.Fatal("Error encoding image:", err)
+	}
+	pngPath := path.Join(t.TempDir(), "large.png")
+	if err := os.WriteFile(pngPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal("Error writing file:", err)
+	}
+	jpgPath := path.Join("testdata", "exif", "thumbnail.jpg")
+	for _, filePath := range []string{pngPath, jpgPath} {
+		for _, reduce := range []bool{false, true} {
+			_, err := IconFromFile(filePath, FileOptions{Reduce: reduce,
+				Open: OpenOptions{MaxPixels: 1000}})
+			if !errors.Is(err, ErrImageTooLarge) {
+				t.Errorf("%s, reduce %v: expected ErrImageTooLarge, got %v.",
+					filePath, reduce, err)
+			}
+		}
+	}
+}
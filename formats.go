@@ -0,0 +1,16 @@
+//go:build extformats
+// +build extformats
+
+package images3
+
+// Building with tag extformats registers pure-Go decoders of WebP,
+// BMP and TIFF formats, so that funcs Open and Decode support them.
+// The decoders are registered on import, and the tag keeps them and
+// the golang.org/x/image dependency out of default builds:
+//
+//	go build -tags extformats
+import (
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
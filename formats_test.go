@@ -0,0 +1,72 @@
+//go:build extformats
+// +build extformats
+
+package images3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path"
+	"testing"
+)
+
+func TestExtendedFormats(t *testing.T) {
+	p := path.Join("testdata", "formats")
+	img, err := Open(path.Join(p, "image.png"))
+	if err != nil {
+		t.Fatal("Error opening image:", err)
+	}
+	iconPNG := Icon(img, "")
+	for _, file := range []string{"image.webp", "image.bmp", "image.tif"} {
+		img, err := Open(path.Join(p, file))
+		if err != nil {
+			t.Errorf("Error opening %s: %v", file, err)
+			continue
+		}
+		icon := Icon(img, "")
+		if !Similar(iconPNG, icon) {
+			t.Errorf("Expected similarity of %s to image.png.", file)
+		}
+		// The fixtures are lossless copies of the PNG.
+		m1, m2, m3 := EucMetric(iconPNG, icon)
+		if m1 != 0 || m2 != 0 || m3 != 0 {
+			t.Errorf("Expected zero metrics for %s, got %v, %v, %v.",
+				file, m1, m2, m3)
+		}
+	}
+}
+
+// TIFF images with palette indices out of palette range are
+// an error, not a panic of icon generation.
+func TestTIFFPaletteIndex(t *testing.T) {
+	// Header, 2x2 pixels of palette indices, 2 color palette
+	// and the image file directory.
+	var b bytes.Buffer
+	le := binary.LittleEndian
+	b.WriteString("II*\x00")
+	binary.Write(&b, le, uint32(24))
+	b.Write([]byte{0, 1, 5, 255})
+	binary.Write(&b, le, []uint16{0, 0xffff, 0, 0xffff, 0, 0xffff})
+	entries := [][4]uint32{ // Tag, type, count, value.
+		{256, 3, 1, 2},  // ImageWidth.
+		{257, 3, 1, 2},  // ImageLength.
+		{258, 3, 1, 8},  // BitsPerSample.
+		{259, 3, 1, 1},  // Compression: none.
+		{262, 3, 1, 3},  // PhotometricInterpretation: palette.
+		{273, 4, 1, 8},  // StripOffsets.
+		{277, 3, 1, 1},  // SamplesPerPixel.
+		{278, 3, 1, 2},  // RowsPerStrip.
+		{279, 4, 1, 4},  // StripByteCounts.
+		{320, 3, 6, 12}, // ColorMap.
+	}
+	binary.Write(&b, le, uint16(len(entries)))
+	for _, e := range entries {
+		binary.Write(&b, le, []uint16{uint16(e[0]), uint16(e[1])})
+		binary.Write(&b, le, []uint32{e[2], e[3]})
+	}
+	binary.Write(&b, le, uint32(0))
+
+	if _, _, err := Decode(&b); err == nil {
+		t.Error("Expected an error for palette indices out of range.")
+	}
+}
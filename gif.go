@@ -0,0 +1,180 @@
+package images3
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"os"
+)
+
+// IconsFromGIF generates icons for all frames of an animated GIF
+// (see func GIFIcons). Funcs Open and Icon use the first frame only.
+// Before decoding, the canvas size is checked against default limits
+// of OpenOptions, and so is the total number of pixels of all frames,
+// which are kept in memory together.
+func IconsFromGIF(path string) ([]IconT, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config, err := gif.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var opts OpenOptions
+	if err = checkSize(config.Width, config.Height, opts); err != nil {
+		return nil, err
+	}
+	maxPixels, _ := opts.limits()
+	if n := gifFramePixels(data); maxPixels > 0 && n > int64(maxPixels) {
+		return nil, fmt.Errorf("%w: %d pixels of frames",
+			ErrImageTooLarge, n)
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	icons := GIFIcons(g, 1)
+	for i := range icons {
+		icons[i].Path = path
+	}
+	return icons, nil
+}
+
+// gifFramePixels returns the total number of pixels of frames of
+// GIF data, found by a scan of GIF blocks without decoding of frames.
+// The scan stops at malformed data, which is left for decoding.
+func gifFramePixels(data []byte) int64 {
+	// Header and logical screen descriptor.
+	if len(data) < 13 {
+		return 0
+	}
+	pos := 13
+	if data[10]&0x80 != 0 {
+		pos += 3 << (data[10]&7 + 1) // Global color table.
+	}
+	var n int64
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: // Extension with label.
+			pos = skipSubBlocks(data, pos+2)
+		case 0x2c: // Image descriptor.
+			if pos+10 > len(data) {
+				return n
+			}
+			width := int64(data[pos+5]) | int64(data[pos+6])<<8
+			height := int64(data[pos+7]) | int64(data[pos+8])<<8
+			n += width * height
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&7 + 1) // Local color table.
+			}
+			// Image data follows the LZW minimum code size.
+			pos = skipSubBlocks(data, pos+1)
+		default: // Trailer.
+			return n
+		}
+	}
+	return n
+}
+
+// skipSubBlocks returns the position after GIF data sub-blocks
+// starting at pos.
+func skipSubBlocks(data []byte, pos int) int {
+	for pos < len(data) {
+		size := int(data[pos])
+		pos++
+		if size == 0 {
+			return pos
+		}
+		pos += size
+	}
+	return len(data)
+}
+
+// GIFIcons generates icons for every step-th frame of an animated
+// GIF, starting with the first one. Frames are composited on the
+// canvas as they are displayed, applying disposal methods of
+// preceding frames, so that icons of partial frames show the whole
+// picture. Area cleared by background disposal is transparent,
+// as in web browsers. ImgSize of the icons is the canvas size.
+func GIFIcons(g *gif.GIF, step int) []IconT {
+	if step < 1 {
+		step = 1
+	}
+	canvasRect := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if canvasRect.Empty() && len(g.Image) > 0 {
+		canvasRect = g.Image[0].Bounds()
+	}
+	canvas := image.NewRGBA(canvasRect)
+	var previous *image.RGBA
+	icons := make([]IconT, 0, (len(g.Image)+step-1)/step)
+	for i, frame := range g.Image {
+		disposal := byte(gif.DisposalNone)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		if disposal == gif.DisposalPrevious {
+			if previous == nil {
+				previous = image.NewRGBA(canvasRect)
+			}
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		if i%step == 0 {
+			icons = append(icons, Icon(canvas, ""))
+		}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent,
+				image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			copy(canvas.Pix, previous.Pix)
+		}
+	}
+	return icons
+}
+
+// SimilarFrames compares icon sequences of 2 animations (see func
+// GIFIcons) with func Similar. Sequences are aligned at all offsets,
+// where frame i of seqA corresponds to frame i-offset of seqB.
+// It returns the offset with the largest number of similar aligned
+// frames and that number as a fraction of the shorter sequence
+// length. So an animation containing all frames of another one
+// gives fraction 1. Of equally good offsets the one closest to 0
+// is returned.
+func SimilarFrames(seqA, seqB []IconT) (fraction float64, offset int) {
+	if len(seqA) == 0 || len(seqB) == 0 {
+		return 0, 0
+	}
+	// Similarity verdicts of all frame pairs.
+	similar := make([][]bool, len(seqA))
+	for i := range seqA {
+		similar[i] = make([]bool, len(seqB))
+		for j := range seqB {
+			similar[i][j] = Similar(seqA[i], seqB[j])
+		}
+	}
+	best := -1
+	for o := -(len(seqB) - 1); o < len(seqA); o++ {
+		count := 0
+		for i := range seqA {
+			if j := i - o; j >= 0 && j < len(seqB) && similar[i][j] {
+				count++
+			}
+		}
+		if count > best || (count == best && abs(o) < abs(offset)) {
+			best, offset = count, o
+		}
+	}
+	shorter := len(seqA)
+	if len(seqB) < shorter {
+		shorter = len(seqB)
+	}
+	return float64(best) / float64(shorter), offset
+}
@@ -0,0 +1,160 @@
+package images3
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestIconsFromGIF(t *testing.T) {
+	file := path.Join("testdata", "gif", "animation.gif")
+	icons, err := IconsFromGIF(file)
+	if err != nil {
+		t.Fatal("Error reading animation:", err)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal("Error opening file:", err)
+	}
+	defer f.Close()
+	g, err := gif.DecodeAll(f)
+	if err != nil {
+		t.Fatal("Error decoding animation:", err)
+	}
+	if len(icons) != len(g.Image) {
+		t.Fatalf("Expected %d icons, got %d.", len(g.Image), len(icons))
+	}
+
+	// Canvases composited by hand: a full photo frame, a partial
+	// frame disposed to previous, a partial frame disposed to
+	// background, another partial frame and a new photo frame.
+	canvas := func(frames ...int) *image.RGBA {
+		img := image.NewRGBA(image.Rect(0, 0, 160, 120))
+		for _, i := range frames {
+			draw.Draw(img, g.Image[i].Bounds(), g.Image[i],
+				g.Image[i].Bounds().Min, draw.Over)
+		}
+		return img
+	}
+	cleared := canvas(0)
+	draw.Draw(cleared, g.Image[2].Bounds(), image.Transparent,
+		image.Point{}, draw.Src)
+	draw.Draw(cleared, g.Image[3].Bounds(), g.Image[3],
+		g.Image[3].Bounds().Min, draw.Over)
+	want := []image.Image{canvas(0), canvas(0, 1), canvas(0, 2),
+		cleared, canvas(0, 4)}
+
+	for i, icon := range icons {
+		m1, m2, m3 := EucMetric(icon, Icon(want[i], ""))
+		if m1 != 0 || m2 != 0 || m3 != 0 {
+			t.Errorf("Frame %d: expected zero metrics, got %v, %v, %v.",
+				i, m1, m2, m3)
+		}
+		if icon.ImgSize != (Point{160, 120}) || icon.Path != file {
+			t.Errorf("Frame %d: expected canvas size and path, got %v, %v.",
+				i, icon.ImgSize, icon.Path)
+		}
+	}
+
+	// Func Open decodes the first frame.
+	img, err := Open(file)
+	if err != nil {
+		t.Fatal("Error opening image:", err)
+	}
+	if !Similar(Icon(img, ""), icons[0]) {
+		t.Error("Expected similarity of the first frame to Open result.")
+	}
+
+	// Sampled frames.
+	sampled := GIFIcons(g, 2)
+	if len(sampled) != 3 {
+		t.Fatalf("Expected 3 sampled icons, got %d.", len(sampled))
+	}
+	for i, icon := range sampled {
+		m1, m2, m3 := EucMetric(icon, icons[2*i])
+		if m1 != 0 || m2 != 0 || m3 != 0 {
+			t.Errorf("Sample %d: expected zero metrics, got %v, %v, %v.",
+				i, m1, m2, m3)
+		}
+	}
+}
+
+func TestIconsFromGIFLimits(t *testing.T) {
+	data, err := os.ReadFile(path.Join("testdata", "gif", "animation.gif"))
+	if err != nil {
+		t.Fatal("Error reading file:", err)
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal("Error decoding animation:", err)
+	}
+	var want int64
+	for _, frame := range g.Image {
+		want += int64(frame.Bounds().Dx() * frame.Bounds().Dy())
+	}
+	if n := gifFramePixels(data); n != want {
+		t.Errorf("Expected %d pixels of frames, got %d.", want, n)
+	}
+
+	// 3 frames of 10000x10000 pixels, each of which is within
+	// the limit, with no image data.
+	data = []byte("GIF89a\x10\x27\x10\x27\x80\x00\x00")
+	data = append(data, 0, 0, 0, 255, 255, 255) // Global color table.
+	for i := 0; i < 3; i++ {
+		data = append(data, 0x2c, 0, 0, 0, 0, 0x10, 0x27, 0x10, 0x27, 0,
+			2, 0)
+	}
+	data = append(data, 0x3b)
+	file := path.Join(t.TempDir(), "large.gif")
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		t.Fatal("Error writing file:", err)
+	}
+	if n := gifFramePixels(data); n != 3e8 {
+		t.Errorf("Expected %d pixels of frames, got %d.", int64(3e8), n)
+	}
+	if _, err := IconsFromGIF(file); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("Expected ErrImageTooLarge, got %v.", err)
+	}
+}
+
+func TestSimilarFrames(t *testing.T) {
+	icons := make(map[string]IconT)
+	for _, name := range []string{"large.jpg", "flipped.jpg",
+		"uniform-green.png", "uniform-white.png", "uniform-black.png"} {
+		img, err := Open(path.Join("testdata", "euclidean", name))
+		if err != nil {
+			t.Fatal("Error opening image:", err)
+		}
+		icons[name] = Icon(img, name)
+	}
+	seq := []IconT{icons["large.jpg"], icons["flipped.jpg"],
+		icons["uniform-green.png"], icons["uniform-white.png"]}
+	// A different first frame and a shift by one frame.
+	changed := append([]IconT{icons["uniform-black.png"]}, seq[1:]...)
+	shifted := append([]IconT{icons["uniform-black.png"]}, seq[:3]...)
+
+	for _, c := range []struct {
+		name     string
+		a, b     []IconT
+		fraction float64
+		offset   int
+	}{
+		{"same", seq, seq, 1, 0},
+		{"changed", seq, changed, 0.75, 0},
+		{"shifted", seq, shifted, 0.75, -1},
+		{"reverse shifted", shifted, seq, 0.75, 1},
+		{"contained", seq, seq[1:3], 1, 1},
+		{"empty", seq, nil, 0, 0},
+	} {
+		fraction, offset := SimilarFrames(c.a, c.b)
+		if fraction != c.fraction || offset != c.offset {
+			t.Errorf("%s: expected %v, %v, got %v, %v.", c.name,
+				c.fraction, c.offset, fraction, offset)
+		}
+	}
+}
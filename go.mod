@@ -2,4 +2,7 @@ module github.com/vitali-fedulov/images3
 
 go 1.16
 
-require github.com/vitali-fedulov/hyper v1.0.1
+require (
+	github.com/vitali-fedulov/hyper v1.0.1
+	golang.org/x/image v0.18.0
+)
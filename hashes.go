@@ -13,6 +13,8 @@ import (
 // This hash can then be used for a record or a query.
 // When used for a record, you will need a hash set made
 // with func HashSet for a query. And vice versa.
+// Hyper points are pixel positions in the icon. For icons of
+// non-default size, scale them with func ScalePoints.
 // To better understand CentralHash, read the following doc:
 // https://vitali-fedulov.github.io/algorithm-for-hashing-high-dimensional-float-vectors.html
 func CentralHash(icon IconT, hyperPoints []Point,
@@ -39,6 +41,8 @@ func CentralHash(icon IconT, hyperPoints []Point,
 // This hash set can then be used for records or a query.
 // When used for a query, you will need a hash made with
 // func CentralHash as a record. And vice versa.
+// Hyper points are pixel positions in the icon. For icons of
+// non-default size, scale them with func ScalePoints.
 // To better understand HashSet, read the following doc:
 // https://vitali-fedulov.github.io/algorithm-for-hashing-high-dimensional-float-vectors.html
 func HashSet(icon IconT, hyperPoints []Point,
@@ -71,6 +75,19 @@ var HyperPoints10 = []Point{
 	{2, 5}, {3, 3}, {3, 8}, {4, 6}, {5, 2},
 	{6, 4}, {6, 7}, {8, 2}, {8, 5}, {8, 8}}
 
+// ScalePoints maps hyper points of the default 11x11 icon, such as
+// HyperPoints10 or points from CustomPoints, to icons of another
+// size (see IconOptions). Pixel centers are mapped to pixel centers.
+func ScalePoints(points []Point, size int) []Point {
+	scaled := make([]Point, len(points))
+	for i, p := range points {
+		scaled[i] = Point{
+			(2*p.X + 1) * size / (2 * iconSize),
+			(2*p.Y + 1) * size / (2 * iconSize)}
+	}
+	return scaled
+}
+
 // CustomPoints is a utility function to create hyper points similar
 // to HyperPoints10. It is needed if you are planning to use
 // the package with billions of images, and might need higher number
@@ -198,3 +215,22 @@ func nearest(s map[Point]bool, o Point) Point {
 	}
 	return minKey(d)
 }
+
+// HashSetMirrored generates a hash set as func HashSet does, for the
+// icon and its horizontally and vertically mirrored copies. Used as
+// a query, it finds records (see func CentralHash) of mirrored images.
+func HashSetMirrored(icon IconT, hyperPoints []Point,
+	epsPercent float64, numBuckets int) []uint64 {
+	var set []uint64
+	seen := make(map[uint64]bool)
+	for _, t := range mirrors {
+		for _, h := range HashSet(icon.Transformed(t), hyperPoints,
+			epsPercent, numBuckets) {
+			if !seen[h] {
+				seen[h] = true
+				set = append(set, h)
+			}
+		}
+	}
+	return set
+}
@@ -287,3 +287,39 @@ func TestNearest(t *testing.T) {
 		t.Errorf("Want %v, got %v", want, got)
 	}
 }
+
+func TestScalePoints(t *testing.T) {
+	got := ScalePoints(HyperPoints10, 11)
+	if !reflect.DeepEqual(got, HyperPoints10) {
+		t.Errorf("Want %v, got %v", HyperPoints10, got)
+	}
+	got = ScalePoints([]Point{{0, 0}, {5, 5}, {10, 10}}, 24)
+	want := []Point{{1, 1}, {12, 12}, {22, 22}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Want %v, got %v", want, got)
+	}
+}
+
+func TestCentralHashSized(t *testing.T) {
+	img, err := Open("testdata/euclidean/large.jpg")
+	if err != nil {
+		t.Error("Error opening image:", err)
+	}
+	iconA := IconWithOptions(img, "", IconOptions{Size: 24})
+	img, err = Open("testdata/euclidean/small.jpg")
+	if err != nil {
+		t.Error("Error opening image:", err)
+	}
+	iconB := IconWithOptions(img, "", IconOptions{Size: 24})
+	points := ScalePoints(HyperPoints10, 24)
+	hash := CentralHash(iconA, points, 0.25, 4)
+	found := false
+	for _, h := range HashSet(iconB, points, 0.25, 4) {
+		if h == hash {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Expected a hash match for 24x24 icons.")
+	}
+}
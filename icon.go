@@ -1,13 +1,16 @@
 package images3
 
 import (
+	"errors"
+	"fmt"
 	"image"
 	"image/color"
+	"math"
 )
 
 // Icon parameters.
 const (
-	iconSize = 11 // Image resolution of the icon
+	iconSize = 11 // Default image resolution of the icon
 	// is very small (11x11 pixels), therefore original
 	// image details are lost in downsampling, except
 	// when source images have very low resolution
@@ -21,6 +24,11 @@ const (
 	oneNinth         = float32(1) / float32(9)
 )
 
+// MaxIconSize is the maximal icon resolution. Larger values of
+// IconOptions.Size are reduced to it, which keeps the memory of
+// icon generation within about 10 MB per image.
+const MaxIconSize = 64
+
 // Icon has square shape. Its pixels are float32 values
 // for 3 channels. Float32 is intentional to preserve color
 // relationships from the full-size image.
@@ -28,76 +36,263 @@ type IconT struct {
 	Pixels  []float32
 	ImgSize Point  // Original image size.
 	Path    string // Original image path.
+	Size    int    // Icon resolution (pixels per side).
+	// Image rectangle used for the icon when borders are
+	// trimmed (see IconOptions), otherwise empty.
+	Trimmed image.Rectangle
+	// Color space of pixel values. Icons of different color
+	// spaces cannot be compared with each other.
+	ColorSpace ColorSpace
+	// Minimal and maximal values of the 3 channels before
+	// normalization, which keep brightness and contrast of
+	// the image (see func ExposureMetric).
+	Min, Max [3]float32
+	// Raw is true for icons generated without normalization
+	// (see IconOptions). Raw and normalized icons cannot be
+	// compared with each other.
+	Raw bool
 }
 
 type Point image.Point
 
+// IconOptions are parameters of icon generation with func
+// IconWithOptions. The zero value produces the same icons
+// as func Icon.
+type IconOptions struct {
+	// Size is icon resolution (pixels per side). 0 means the
+	// default of 11 pixels. Larger icons such as 16 or 24 keep
+	// more detail, which helps to distinguish document scans
+	// and screenshots merged by the default size. Sizes above
+	// MaxIconSize mean MaxIconSize.
+	Size int
+	// Resample is the method of initial image resizing. Default
+	// ResampleNearest is the fastest. ResampleArea averages all
+	// source pixels, which avoids aliasing in large photos with
+	// high-frequency detail, so that re-encoded copies of such
+	// photos get closer icons.
+	Resample Resampling
+	// Background, when not nil, is the color translucent image
+	// pixels are composited over before the conversion to YCbCr.
+	// Then a logo with transparency gets the same icon as the
+	// logo drawn on the background. When nil, translucent pixels
+	// are effectively composited over black.
+	Background color.Color
+	// Unpremultiply averages colors of translucent pixels with
+	// their alpha values as weights, so that icon colors do not
+	// depend on transparency. Areas with no opaque pixels take
+	// the Background color (black when nil). Otherwise Background
+	// is not used.
+	Unpremultiply bool
+	// Trim enables cropping of near-uniform image borders, such
+	// as black bars of video stills or margins of scans, before
+	// icon generation (see func TrimBorders). ImgSize of the icon
+	// is then the size of the cropped image, and IconT.Trimmed
+	// is the cropped rectangle.
+	Trim bool
+	// TrimTolerance is the color tolerance of border trimming in
+	// 8-bit units. 0 means the default of 12.
+	TrimTolerance int
+	// ColorSpace is the color space of icon pixels. Default
+	// ColorYCbCr suits most images. ColorLab is perceptually
+	// uniform and compared by color difference Delta E, which
+	// is better for color-critical images such as product photos.
+	ColorSpace ColorSpace
+	// Linear averages colors in linear light instead of gamma
+	// encoded sRGB values, as gamma-correct image resizing tools
+	// do. Then fine high-contrast detail does not darken icons,
+	// and icons of sharp originals stay closer to icons of their
+	// copies resized by such tools. With ResampleArea initial
+	// resizing is done with func ResizeByAreaLinear.
+	Linear bool
+	// Raw disables normalization of icon channels, so that
+	// icons keep absolute brightness and color levels. Then
+	// func Similar is sensitive to exposure differences.
+	Raw bool
+}
+
+// Resampling is a method of image resizing.
+type Resampling int
+
+const (
+	// ResampleNearest resizes with func ResizeByNearest.
+	ResampleNearest Resampling = iota
+	// ResampleArea resizes with func ResizeByArea.
+	ResampleArea
+)
+
 // Icon generates image signature (icon) with related info.
 // The icon data can then be stored in a database and used
 // for comparisons.
 func Icon(img image.Image, path string) IconT {
+	return IconWithOptions(img, path, IconOptions{})
+}
+
+// IconE generates an icon as func Icon does, but returns
+// ErrEmptyImage and an empty icon for nil or empty images,
+// which have no meaningful icon or proportions.
+func IconE(img image.Image, path string) (IconT, error) {
+	if img == nil || img.Bounds().Empty() {
+		return EmptyIcon(), ErrEmptyImage
+	}
+	return Icon(img, path), nil
+}
+
+// IconWithOptions generates an icon as func Icon does, but with
+// custom parameters. Icons of different sizes cannot be compared
+// with each other.
+func IconWithOptions(img image.Image, path string,
+	opts IconOptions) IconT {
+
+	size := optionSize(opts.Size)
+	largeSize := size*2 + 1
+	resizedSize := largeSize * samples
+
+	var trimmed image.Rectangle
+	if opts.Trim {
+		tolerance := opts.TrimTolerance
+		if tolerance <= 0 {
+			tolerance = trimTolerance
+		}
+		trimmed = TrimBorders(img, tolerance)
+		img = subImage(img, trimmed)
+	}
 
 	// Resizing to a large icon approximating average color
 	// values of the source image. YCbCr space is used instead
 	// of RGB for better results in image comparison.
-	resImg, imgSizeX, imgSizeY :=
-		ResizeByNearest(img, resizedImgSize, resizedImgSize)
-	largeIcon := sizedIcon(largeIconSize)
-	var r, g, b, sumR, sumG, sumB uint32
+	resize := ResizeByNearest
+	if opts.Resample == ResampleArea {
+		resize = ResizeByArea
+	}
+	if opts.Linear && opts.Resample == ResampleArea {
+		resize = ResizeByAreaLinear
+	}
+	resImg, imgSizeX, imgSizeY := resize(img, resizedSize, resizedSize)
+	largeIcon := sizedIcon(largeSize)
+	// Background color as 8-bit premultiplied values.
+	var bgR, bgG, bgB float32
+	if opts.Background != nil {
+		r, g, b, _ := opts.Background.RGBA()
+		bgR, bgG, bgB = float32(r>>8), float32(g>>8), float32(b>>8)
+		if opts.Linear {
+			bgR, bgG, bgB = linearLUT[r>>8], linearLUT[g>>8], linearLUT[b>>8]
+		}
+	}
+	var sumR, sumG, sumB, sumA uint32
+	var linR, linG, linB, totR, totG, totB float32
+	var avgR, avgG, avgB float32
+	var p []uint8
 	// For each pixel of the largeIcon.
-	for x := 0; x < largeIconSize; x++ {
-		for y := 0; y < largeIconSize; y++ {
-			sumR, sumG, sumB = 0, 0, 0
-			// Sum over pixels of resImg.
+	for x := 0; x < largeSize; x++ {
+		for y := 0; y < largeSize; y++ {
+			sumR, sumG, sumB, sumA = 0, 0, 0, 0
+			linR, linG, linB = 0, 0, 0
+			// Sum over pixels of resImg, reading 8-bit values
+			// directly from its pixel slice.
 			for m := 0; m < samples; m++ {
 				for n := 0; n < samples; n++ {
-					r, g, b, _ =
-						resImg.At(
-							x*samples+m, y*samples+n).RGBA()
-					sumR += r >> 8
-					sumG += g >> 8
-					sumB += b >> 8
+					p = resImg.Pix[resImg.PixOffset(
+						x*samples+m, y*samples+n):]
+					if opts.Linear {
+						r, g, b := linearPixel(p)
+						linR, linG, linB = linR+r, linG+g, linB+b
+					} else {
+						sumR += uint32(p[0])
+						sumG += uint32(p[1])
+						sumB += uint32(p[2])
+					}
+					sumA += uint32(p[3])
 				}
 			}
-			yc, cb, cr := yCbCr(
-				float32(sumR)*invSamplePixels2,
-				float32(sumG)*invSamplePixels2,
-				float32(sumB)*invSamplePixels2)
-			set(largeIcon, largeIconSize,
-				Point{x, y}, yc, cb, cr)
+			totR, totG, totB = float32(sumR), float32(sumG), float32(sumB)
+			if opts.Linear {
+				totR, totG, totB = linR, linG, linB
+			}
+			switch {
+			case opts.Unpremultiply && sumA == 0:
+				avgR, avgG, avgB = bgR, bgG, bgB
+			case opts.Unpremultiply:
+				// Alpha-weighted average of colors.
+				k := 255 / float32(sumA)
+				avgR = totR * k
+				avgG = totG * k
+				avgB = totB * k
+			case opts.Background != nil:
+				// Compositing over the background, which can be
+				// done on sums, as it is linear.
+				k := float32(255*samples*samples-sumA) / 255
+				avgR = (totR + k*bgR) * invSamplePixels2
+				avgG = (totG + k*bgG) * invSamplePixels2
+				avgB = (totB + k*bgB) * invSamplePixels2
+			default:
+				avgR = totR * invSamplePixels2
+				avgG = totG * invSamplePixels2
+				avgB = totB * invSamplePixels2
+			}
+			var c1, c2, c3 float32
+			switch {
+			case opts.Linear:
+				// Linear RGB is kept for blurring and converted
+				// afterwards (see func encodeLinear).
+				c1, c2, c3 = avgR, avgG, avgB
+			case opts.ColorSpace == ColorLab:
+				c1, c2, c3 = lab(avgR, avgG, avgB)
+			default:
+				c1, c2, c3 = yCbCr(avgR, avgG, avgB)
+			}
+			set(largeIcon, largeSize,
+				Point{x, y}, c1, c2, c3)
 		}
 	}
 
 	// Box blur filter with resizing to the final icon of smaller size.
 
-	icon := sizedIcon(iconSize)
+	icon := sizedIcon(size)
 	// Pixel positions in the final icon.
 	var xd, yd int
 	var c1, c2, c3, s1, s2, s3 float32
 
 	// For pixels of source largeIcon with stride 2.
-	for x := 1; x < largeIconSize-1; x += 2 {
+	for x := 1; x < largeSize-1; x += 2 {
 		xd = x / 2
-		for y := 1; y < largeIconSize-1; y += 2 {
+		for y := 1; y < largeSize-1; y += 2 {
 			yd = y / 2
 			// For each pixel of a 3x3 box.
 			for n := -1; n <= 1; n++ {
 				for m := -1; m <= 1; m++ {
 					c1, c2, c3 =
-						get(largeIcon, largeIconSize,
+						get(largeIcon, largeSize,
 							Point{x + n, y + m})
 					s1, s2, s3 = s1+c1, s2+c2, s3+c3
 				}
 			}
-			set(icon, iconSize, Point{xd, yd},
+			set(icon, size, Point{xd, yd},
 				s1*oneNinth, s2*oneNinth, s3*oneNinth)
 			s1, s2, s3 = 0, 0, 0
 		}
 	}
 
+	if opts.Linear {
+		icon.encodeLinear(size, opts.ColorSpace)
+	}
 	icon.ImgSize = Point{imgSizeX, imgSizeY}
 	icon.Path = path
-	icon.normalize(iconSize)
+	icon.Size = size
+	icon.Trimmed = trimmed
+	icon.ColorSpace = opts.ColorSpace
+	icon.Raw = opts.Raw
+	for ch := 0; ch < 3; ch++ {
+		icon.Min[ch], icon.Max[ch] = icon.channelRange(size, ch)
+	}
+	switch {
+	case opts.Raw:
+	case opts.ColorSpace == ColorLab:
+		// Stretching of a* and b* channels would change colors,
+		// so only lightness is normalized.
+		icon.normalizeChannel(size, 0)
+	default:
+		icon.normalize(size)
+	}
 
 	return icon
 }
@@ -111,6 +306,60 @@ func EmptyIcon() (icon IconT) {
 	return icon
 }
 
+// Errors of icon validation and comparison. Test for them
+// with errors.Is.
+var (
+	ErrInvalidIcon  = errors.New("images3: invalid icon")
+	ErrIconMismatch = errors.New("images3: mismatched icons")
+)
+
+// Validate checks that the icon can be compared with other icons:
+// its pixel slice length matches its size, pixel values are finite,
+// and the original image size is not empty. Icons of func EmptyIcon
+// are invalid.
+func (icon IconT) Validate() error {
+	if icon.Pixels == nil {
+		return fmt.Errorf("%w: no pixels", ErrInvalidIcon)
+	}
+	size := icon.size()
+	if size > MaxIconSize {
+		return fmt.Errorf("%w: size %d", ErrInvalidIcon, size)
+	}
+	if len(icon.Pixels) != 3*size*size {
+		return fmt.Errorf("%w: %d pixel values for size %d",
+			ErrInvalidIcon, len(icon.Pixels), size)
+	}
+	for _, c := range icon.Pixels {
+		if math.IsNaN(float64(c)) || math.IsInf(float64(c), 0) {
+			return fmt.Errorf("%w: pixel value %v", ErrInvalidIcon, c)
+		}
+	}
+	if icon.ImgSize.X <= 0 || icon.ImgSize.Y <= 0 {
+		return fmt.Errorf("%w: image size %v", ErrInvalidIcon, icon.ImgSize)
+	}
+	return nil
+}
+
+// optionSize returns icon size for the value of IconOptions.Size.
+func optionSize(size int) int {
+	if size <= 0 {
+		return iconSize
+	}
+	if size > MaxIconSize {
+		return MaxIconSize
+	}
+	return size
+}
+
+// size returns icon resolution. Icons with no recorded
+// Size are of the default size.
+func (icon IconT) size() int {
+	if icon.Size > 0 {
+		return icon.Size
+	}
+	return iconSize
+}
+
 func sizedIcon(size int) (icon IconT) {
 	icon.Pixels = make([]float32, size*size*3)
 	return icon
@@ -153,8 +402,9 @@ func yCbCr(r, g, b float32) (yc, cb, cr float32) {
 
 // lumaVector returns luma values at sample pixels of the icon.
 func lumaVector(icon IconT, sample []Point) (v []float64) {
+	size := icon.size()
 	for i := range sample {
-		c1, _, _ := get(icon, iconSize, sample[i])
+		c1, _, _ := get(icon, size, sample[i])
 		v = append(v, float64(c1))
 	}
 	return v
@@ -163,7 +413,11 @@ func lumaVector(icon IconT, sample []Point) (v []float64) {
 // ToRGBA transforms a sized icon to image.RGBA. This is
 // an auxiliary function, used to visually evaluate an icon
 // in a separate program (but not in tests, which could be brittle).
+// Size 0 means the size recorded in the icon.
 func (icon IconT) ToRGBA(size int) *image.RGBA {
+	if size <= 0 {
+		size = icon.size()
+	}
 	img := image.NewRGBA(image.Rect(0, 0, size, size))
 	for x := 0; x < size; x++ {
 		for y := 0; y < size; y++ {
@@ -175,62 +429,39 @@ func (icon IconT) ToRGBA(size int) *image.RGBA {
 	return img
 }
 
+// channelRange returns extreme values of channel ch of an icon.
+func (src IconT) channelRange(size, ch int) (cMin, cMax float32) {
+	cMin, cMax = 256, 0
+	for _, v := range src.Pixels[ch*size*size : (ch+1)*size*size] {
+		if v > cMax {
+			cMax = v
+		}
+		if v < cMin {
+			cMin = v
+		}
+	}
+	return cMin, cMax
+}
+
 // Normalize stretches histograms for the 3 channels of an icon, so that
 // minimum and maximum values of each are 0 and 255 correspondingly.
 func (src IconT) normalize(size int) {
-
-	numPixels := size * size
-	var c1Min, c2Min, c3Min, c1Max, c2Max, c3Max float32
-	c1Min, c2Min, c3Min = 256, 256, 256
-	c1Max, c2Max, c3Max = 0, 0, 0
-	var n int
-
-	// Looking for extreme values.
-	for n = 0; n < numPixels; n++ {
-		// Channel 1.
-		if src.Pixels[n] > c1Max {
-			c1Max = src.Pixels[n]
-		}
-		if src.Pixels[n] < c1Min {
-			c1Min = src.Pixels[n]
-		}
-		// Channel 2.
-		if src.Pixels[n+numPixels] > c2Max {
-			c2Max = src.Pixels[n+numPixels]
-		}
-		if src.Pixels[n+numPixels] < c2Min {
-			c2Min = src.Pixels[n+numPixels]
-		}
-		// Channel 3.
-		if src.Pixels[n+2*numPixels] > c3Max {
-			c3Max = src.Pixels[n+2*numPixels]
-		}
-		if src.Pixels[n+2*numPixels] < c3Min {
-			c3Min = src.Pixels[n+2*numPixels]
-		}
+	for ch := 0; ch < 3; ch++ {
+		src.normalizeChannel(size, ch)
 	}
+}
+
+// normalizeChannel stretches the histogram of channel ch of an icon,
+// so that its minimum and maximum values are 0 and 255.
+func (src IconT) normalizeChannel(size, ch int) {
+
+	c := src.Pixels[ch*size*size : (ch+1)*size*size]
+	cMin, cMax := src.channelRange(size, ch)
 
 	// Normalization.
-	if c1Max != c1Min { // Must not divide by zero.
-		for n = 0; n < numPixels; n++ {
-			src.Pixels[n] =
-				(src.Pixels[n] - c1Min) *
-					255 / (c1Max - c1Min)
-		}
-	}
-	if c2Max != c2Min { // Must not divide by zero.
-		for n = 0; n < numPixels; n++ {
-			src.Pixels[n+numPixels] =
-				(src.Pixels[n+numPixels] - c2Min) *
-					255 / (c2Max - c2Min)
-		}
-	}
-	if c3Max != c3Min { // Must not divide by zero.
-		for n = 0; n < numPixels; n++ {
-			src.Pixels[n+2*numPixels] =
-				(src.Pixels[n+2*numPixels] - c3Min) *
-					255 / (c3Max - c3Min)
+	if cMax != cMin { // Must not divide by zero.
+		for n := range c {
+			c[n] = (c[n] - cMin) * 255 / (cMax - cMin)
 		}
 	}
-
 }
@@ -0,0 +1,127 @@
+package images3
+
+import "math"
+
+// Icon8 is a compact icon with pixel values quantized to uint8,
+// taking a quarter of IconT memory. Normalized pixel values are
+// in the range 0-255, so the quantization error is at most 0.5,
+// which changes similarity verdicts only for pairs of images very
+// close to thresholds. Icon8 is made with method IconT.ToIcon8.
+type Icon8 struct {
+	Pixels     []uint8
+	ImgSize    Point  // Original image size.
+	Path       string // Original image path.
+	Size       int    // Icon resolution (pixels per side).
+	ColorSpace ColorSpace
+	Raw        bool
+}
+
+// ToIcon8 converts the icon to Icon8, rounding pixel values.
+// Normalization ranges and the trimmed rectangle are not kept.
+func (icon IconT) ToIcon8() Icon8 {
+	icon8 := Icon8{
+		ImgSize:    icon.ImgSize,
+		Path:       icon.Path,
+		Size:       icon.size(),
+		ColorSpace: icon.ColorSpace,
+		Raw:        icon.Raw}
+	if icon.Pixels == nil {
+		return icon8
+	}
+	icon8.Pixels = make([]uint8, len(icon.Pixels))
+	for i, c := range icon.Pixels {
+		switch {
+		case c <= 0:
+			icon8.Pixels[i] = 0
+		case c >= 255:
+			icon8.Pixels[i] = 255
+		default:
+			icon8.Pixels[i] = uint8(c + 0.5)
+		}
+	}
+	return icon8
+}
+
+// ToIcon converts Icon8 back to IconT.
+func (icon Icon8) ToIcon() IconT {
+	dst := IconT{
+		ImgSize:    icon.ImgSize,
+		Path:       icon.Path,
+		Size:       icon.Size,
+		ColorSpace: icon.ColorSpace,
+		Raw:        icon.Raw}
+	if icon.Pixels == nil {
+		return dst
+	}
+	dst.Pixels = make([]float32, len(icon.Pixels))
+	for i, c := range icon.Pixels {
+		dst.Pixels[i] = float32(c)
+	}
+	return dst
+}
+
+// size returns icon resolution (see func IconT.size).
+func (icon Icon8) size() int {
+	if icon.Size > 0 {
+		return icon.Size
+	}
+	return iconSize
+}
+
+// EucMetric8 returns Euclidean distances between 2 icons as func
+// EucMetric does, so that the same thresholds apply.
+func EucMetric8(iconA, iconB Icon8) (m1, m2, m3 float32) {
+	size := iconA.size()
+	if size != iconB.size() || iconA.ColorSpace != iconB.ColorSpace ||
+		iconA.Raw != iconB.Raw {
+		inf := float32(math.Inf(1))
+		return inf, inf, inf
+	}
+	numIconPixels := size * size
+	var sums [3]uint64
+	for ch := range sums {
+		a := iconA.Pixels[ch*numIconPixels : (ch+1)*numIconPixels]
+		b := iconB.Pixels[ch*numIconPixels : (ch+1)*numIconPixels]
+		var sum uint64
+		for i := range a {
+			d := int32(a[i]) - int32(b[i])
+			sum += uint64(d * d)
+		}
+		sums[ch] = sum
+	}
+	return float32(sums[0]), float32(sums[1]), float32(sums[2])
+}
+
+// Similar8 returns the similarity verdict of func Similar for
+// Icon8 icons.
+func Similar8(iconA, iconB Icon8) bool {
+	if propMetric(iconA.ImgSize, iconB.ImgSize) >= thProp {
+		return false
+	}
+	if iconA.ColorSpace == ColorLab {
+		return deltaE8(iconA, iconB) < thDeltaE
+	}
+	m1, m2, m3 := EucMetric8(iconA, iconB)
+	tY, tCbCr := eucThresholds(iconA.size())
+	return m1 < tY && m2 < tCbCr && m3 < tCbCr
+}
+
+// deltaE8 is func DeltaEMetric for Icon8 icons.
+func deltaE8(iconA, iconB Icon8) float32 {
+	size := iconA.size()
+	if size != iconB.size() || iconA.Raw != iconB.Raw ||
+		iconA.ColorSpace != ColorLab || iconB.ColorSpace != ColorLab {
+		return float32(math.Inf(1))
+	}
+	numIconPixels := size * size
+	var sum float64
+	for i := 0; i < numIconPixels; i++ {
+		dL := float64(int(iconA.Pixels[i])-int(iconB.Pixels[i])) / 2.55
+		da := float64(int(iconA.Pixels[i+numIconPixels]) -
+			int(iconB.Pixels[i+numIconPixels]))
+		db := float64(int(iconA.Pixels[i+2*numIconPixels]) -
+			int(iconB.Pixels[i+2*numIconPixels]))
+		sum += math.Sqrt(dL*dL + da*da + db*db)
+	}
+	return float32(sum / float64(numIconPixels))
+}
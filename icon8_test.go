@@ -0,0 +1,100 @@
+package images3
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestToIcon8(t *testing.T) {
+	for _, icon := range testdataIcons(t) {
+		icon8 := icon.ToIcon8()
+		if icon8.Size != iconSize || icon8.ImgSize != icon.ImgSize ||
+			icon8.Path != icon.Path {
+			t.Fatalf("Expected fields of %s to be kept, got %+v.",
+				icon.Path, icon8)
+		}
+		back := icon8.ToIcon()
+		for i, c := range back.Pixels {
+			if math.Abs(float64(c-icon.Pixels[i])) > 0.5 {
+				t.Fatalf("%s: expected %v, got %v.",
+					icon.Path, icon.Pixels[i], c)
+			}
+		}
+	}
+	if icon8 := EmptyIcon().ToIcon8(); icon8.Pixels != nil {
+		t.Errorf("Expected nil pixels, got %v.", icon8.Pixels)
+	}
+}
+
+func TestSimilar8(t *testing.T) {
+	icons := testdataIcons(t)
+	files, err := filepath.Glob(filepath.Join("testdata", "euclidean", "*"))
+	if err != nil {
+		t.Fatal("Error listing files:", err)
+	}
+	for _, file := range files {
+		img, err := Open(file)
+		if err != nil {
+			t.Fatal("Error opening image:", err)
+		}
+		for _, opts := range []IconOptions{{Size: 16},
+			{ColorSpace: ColorLab}, {Raw: true}} {
+			icons = append(icons, IconWithOptions(img, file, opts))
+		}
+	}
+	icons8 := make([]Icon8, len(icons))
+	for i := range icons {
+		icons8[i] = icons[i].ToIcon8()
+	}
+
+	similar := 0
+	for i := range icons {
+		for j := range icons {
+			want := Similar(icons[i], icons[j])
+			if got := Similar8(icons8[i], icons8[j]); got != want {
+				t.Errorf("Expected similarity %v of %s and %s, got %v.",
+					want, icons[i].Path, icons[j].Path, got)
+			}
+			if want && i != j {
+				similar++
+			}
+			m1, m2, m3 := EucMetric(icons[i], icons[j])
+			n1, n2, n3 := EucMetric8(icons8[i], icons8[j])
+			if math.IsInf(float64(m1), 1) != math.IsInf(float64(n1), 1) {
+				t.Fatalf("Expected matching infinite metrics, got %v, %v.",
+					m1, n1)
+			}
+			// Pixel value differences change by at most 1 with
+			// quantization, so squared distances m of n pixel
+			// values change by at most 2*sqrt(n*m)+n.
+			n := float64(icons[i].size() * icons[i].size())
+			within := func(m, m8 float32) bool {
+				return math.IsInf(float64(m), 1) ||
+					math.Abs(float64(m-m8)) <= 2*math.Sqrt(n*float64(m))+n
+			}
+			if !within(m1, n1) || !within(m2, n2) || !within(m3, n3) {
+				t.Errorf("Expected close metrics for %s and %s, "+
+					"got %v, %v, %v and %v, %v, %v.", icons[i].Path,
+					icons[j].Path, m1, m2, m3, n1, n2, n3)
+			}
+		}
+	}
+	if similar == 0 {
+		t.Error("Expected some similar pairs of distinct icons.")
+	}
+}
+
+func BenchmarkEucMetric8(b *testing.B) {
+	icons := testdataIcons(b)
+	icons8 := make([]Icon8, len(icons))
+	for i := range icons {
+		icons8[i] = icons[i].ToIcon8()
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, icon := range icons8 {
+			EucMetric8(icons8[0], icon)
+		}
+	}
+}
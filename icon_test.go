@@ -1,6 +1,11 @@
 package images3
 
 import (
+	"errors"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
 	"math"
 	"path"
 	"reflect"
@@ -19,7 +24,7 @@ func TestSizedIcon(t *testing.T) {
 
 func TestEmptyIcon(t *testing.T) {
 	icon1 := EmptyIcon()
-	icon2 := IconT{nil, Point{0, 0}, ""}
+	icon2 := IconT{Pixels: nil, ImgSize: Point{0, 0}, Path: ""}
 
 	if !reflect.DeepEqual(icon1.Pixels, icon2.Pixels) {
 		t.Errorf("Icons' Pixels mismatch. They must be equal: %v %v",
@@ -190,3 +195,322 @@ func TestNormalize(t *testing.T) {
 	testNormalize(src, want, t)
 
 }
+
+func TestIconWithOptions(t *testing.T) {
+	filePath := path.Join("testdata", "euclidean", "large.jpg")
+	img, err := Open(filePath)
+	if err != nil {
+		t.Error("Cannot decode", filePath)
+	}
+	for _, size := range []int{0, 11, 16, 24, MaxIconSize + 1, 1000} {
+		icon := IconWithOptions(img, filePath, IconOptions{Size: size})
+		want := size
+		if size == 0 {
+			want = 11
+		} else if size > MaxIconSize {
+			want = MaxIconSize
+		}
+		if icon.Size != want {
+			t.Errorf("Expected icon size %d, got %d.", want, icon.Size)
+		}
+		if len(icon.Pixels) != want*want*3 {
+			t.Errorf("Expected %d pixel values, got %d.",
+				want*want*3, len(icon.Pixels))
+		}
+		if icon.ImgSize.X != 533 || icon.ImgSize.Y != 400 {
+			t.Errorf(
+				"Expected image size (533, 400), got (%d, %d).",
+				icon.ImgSize.X, icon.ImgSize.Y)
+		}
+		rgba := icon.ToRGBA(0)
+		if rgba.Bounds().Dx() != want || rgba.Bounds().Dy() != want {
+			t.Errorf("Expected %dx%d RGBA, got %v.",
+				want, want, rgba.Bounds())
+		}
+	}
+	// Default options must give the same icon as func Icon.
+	if !reflect.DeepEqual(Icon(img, filePath),
+		IconWithOptions(img, filePath, IconOptions{})) {
+		t.Error("Icon and IconWithOptions with zero options differ.")
+	}
+}
+
+func TestIconResampleArea(t *testing.T) {
+	p := path.Join("testdata", "euclidean")
+	imgA, err := Open(path.Join(p, "large.jpg"))
+	if err != nil {
+		t.Error("Error opening image:", err)
+	}
+	imgB, err := Open(path.Join(p, "small.jpg"))
+	if err != nil {
+		t.Error("Error opening image:", err)
+	}
+	opts := IconOptions{Resample: ResampleArea}
+	iconA := IconWithOptions(imgA, "", opts)
+	iconB := IconWithOptions(imgB, "", opts)
+	if iconA.ImgSize != (Point{533, 400}) {
+		t.Errorf("Expected image size (533, 400), got %v.", iconA.ImgSize)
+	}
+	if !Similar(iconA, iconB) {
+		t.Error("Expecting similarity of large.jpg to small.jpg.")
+	}
+	// Area resampling gives an icon close to the nearest-neighbour one.
+	m1, _, _ := EucMetric(iconA, Icon(imgA, ""))
+	if m1 > thY/4 {
+		t.Errorf("Too large distance %v to the nearest-neighbour icon.", m1)
+	}
+}
+
+// genericImage hides the concrete type of an image, so that
+// pixels are read through the image.Image interface.
+type genericImage struct {
+	image.Image
+}
+
+// concreteImages converts an image to types with fast pixel access.
+func concreteImages(t testing.TB, filePath string) map[string]image.Image {
+	img, err := Open(filePath)
+	if err != nil {
+		t.Fatal("Cannot decode", filePath)
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(b)
+	draw.Draw(rgba, b, img, b.Min, draw.Src)
+	nrgba := image.NewNRGBA(b)
+	draw.Draw(nrgba, b, img, b.Min, draw.Src)
+	gray := image.NewGray(b)
+	draw.Draw(gray, b, img, b.Min, draw.Src)
+	paletted := image.NewPaletted(b, palette.Plan9)
+	draw.Draw(paletted, b, img, b.Min, draw.Src)
+	return map[string]image.Image{
+		"YCbCr":    img.(*image.YCbCr),
+		"RGBA":     rgba,
+		"NRGBA":    nrgba,
+		"Gray":     gray,
+		"Paletted": paletted,
+	}
+}
+
+// Palette indices out of palette range, which decoders of some
+// formats may produce, give transparent pixels.
+func TestPalettedIndexRange(t *testing.T) {
+	colors := color.Palette{color.White, color.Black}
+	img := image.NewPaletted(image.Rect(0, 0, 40, 40), colors)
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i % 2 * 200)
+	}
+	at := rgbaFunc(img)
+	if r, g, b, a := at(0, 0); r != 0xffff || g != 0xffff ||
+		b != 0xffff || a != 0xffff {
+		t.Errorf("Expected white, got %v, %v, %v, %v.", r, g, b, a)
+	}
+	if r, g, b, a := at(1, 0); r != 0 || g != 0 || b != 0 || a != 0 {
+		t.Errorf("Expected transparent, got %v, %v, %v, %v.", r, g, b, a)
+	}
+	for _, opts := range []IconOptions{{}, {Trim: true}, {Linear: true}} {
+		if icon := IconWithOptions(img, "", opts); icon.Validate() != nil {
+			t.Errorf("%+v: expected a valid icon.", opts)
+		}
+	}
+}
+
+type subImager interface {
+	SubImage(r image.Rectangle) image.Image
+}
+
+// Fast pixel access must give the same results as the generic one.
+func TestFastPixelAccess(t *testing.T) {
+	filePath := path.Join("testdata", "euclidean", "large.jpg")
+	for name, img := range concreteImages(t, filePath) {
+		// Sub-images have odd offsets of pixel slices.
+		sub := img.(subImager).SubImage(image.Rect(3, 5, 500, 391))
+		for _, img := range []image.Image{img, sub} {
+			if !reflect.DeepEqual(Icon(img, ""),
+				Icon(genericImage{img}, "")) {
+				t.Errorf("Icon mismatch for %s.", name)
+			}
+			dst1, _, _ := ResizeByNearest(img, 100, 77)
+			dst2, _, _ := ResizeByNearest(genericImage{img}, 100, 77)
+			if !reflect.DeepEqual(dst1, dst2) {
+				t.Errorf("ResizeByNearest mismatch for %s.", name)
+			}
+			dst1, _, _ = ResizeByArea(img, 100, 77)
+			dst2, _, _ = ResizeByArea(genericImage{img}, 100, 77)
+			if !reflect.DeepEqual(dst1, dst2) {
+				t.Errorf("ResizeByArea mismatch for %s.", name)
+			}
+		}
+	}
+}
+
+func benchmarkIcon(b *testing.B, name string, generic bool) {
+	img := concreteImages(b, path.Join(
+		"testdata", "euclidean", "large.jpg"))[name]
+	if generic {
+		img = genericImage{img}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Icon(img, "")
+	}
+}
+
+func BenchmarkIconYCbCr(b *testing.B)           { benchmarkIcon(b, "YCbCr", false) }
+func BenchmarkIconYCbCrGeneric(b *testing.B)    { benchmarkIcon(b, "YCbCr", true) }
+func BenchmarkIconRGBA(b *testing.B)            { benchmarkIcon(b, "RGBA", false) }
+func BenchmarkIconRGBAGeneric(b *testing.B)     { benchmarkIcon(b, "RGBA", true) }
+func BenchmarkIconNRGBA(b *testing.B)           { benchmarkIcon(b, "NRGBA", false) }
+func BenchmarkIconNRGBAGeneric(b *testing.B)    { benchmarkIcon(b, "NRGBA", true) }
+func BenchmarkIconGray(b *testing.B)            { benchmarkIcon(b, "Gray", false) }
+func BenchmarkIconGrayGeneric(b *testing.B)     { benchmarkIcon(b, "Gray", true) }
+func BenchmarkIconPaletted(b *testing.B)        { benchmarkIcon(b, "Paletted", false) }
+func BenchmarkIconPalettedGeneric(b *testing.B) { benchmarkIcon(b, "Paletted", true) }
+
+func alphaIcon(t *testing.T, name string, opts IconOptions) IconT {
+	filePath := path.Join("testdata", "alpha", name)
+	img, err := Open(filePath)
+	if err != nil {
+		t.Fatal("Cannot decode", filePath)
+	}
+	return IconWithOptions(img, filePath, opts)
+}
+
+func TestIconBackground(t *testing.T) {
+	white := IconOptions{Background: color.White}
+
+	// Fully transparent pixels must not affect icons.
+	for _, opts := range []IconOptions{{}, white,
+		{Unpremultiply: true}, {Unpremultiply: true, Background: color.White}} {
+		iconA := alphaIcon(t, "logo-transparent.png", opts)
+		iconB := alphaIcon(t, "logo-garbage.png", opts)
+		if !reflect.DeepEqual(iconA.Pixels, iconB.Pixels) {
+			t.Errorf("Transparent pixels changed the icon with %+v.", opts)
+		}
+	}
+
+	// Transparent logo is similar to the logo on white
+	// only when composited over white.
+	iconA := alphaIcon(t, "logo-transparent.png", IconOptions{})
+	iconB := alphaIcon(t, "logo-white.png", IconOptions{})
+	if Similar(iconA, iconB) {
+		t.Error("Expecting non-similarity without a background.")
+	}
+	iconA = alphaIcon(t, "logo-transparent.png", white)
+	iconB = alphaIcon(t, "logo-white.png", white)
+	m1, m2, m3 := EucMetric(iconA, iconB)
+	if m1 > 1 || m2 > 1 || m3 > 1 {
+		t.Errorf("Expecting equal icons, got metrics %v, %v, %v.",
+			m1, m2, m3)
+	}
+	// Opaque images do not depend on the background.
+	iconA = alphaIcon(t, "logo-white.png", IconOptions{})
+	if !reflect.DeepEqual(iconA.Pixels, iconB.Pixels) {
+		t.Error("Background changed an icon of an opaque image.")
+	}
+}
+
+func TestIconUnpremultiply(t *testing.T) {
+	opts := IconOptions{Unpremultiply: true, Background: color.White}
+	iconA := alphaIcon(t, "logo-transparent.png", opts)
+	iconB := alphaIcon(t, "logo-translucent.png", opts)
+	// Small differences come from rounding of premultiplied values.
+	m1, m2, m3 := EucMetric(iconA, iconB)
+	if m1 > 10 || m2 > 10 || m3 > 10 {
+		t.Errorf("Expecting equal icons, got metrics %v, %v, %v.",
+			m1, m2, m3)
+	}
+}
+
+func TestIconE(t *testing.T) {
+	for _, img := range []image.Image{nil,
+		image.NewRGBA(image.Rect(0, 0, 0, 0)),
+		image.NewRGBA(image.Rect(5, 5, 5, 20))} {
+		icon, err := IconE(img, "")
+		if !errors.Is(err, ErrEmptyImage) || icon.Pixels != nil {
+			t.Errorf("Expected ErrEmptyImage and an empty icon, got %v.", err)
+		}
+	}
+	// Degenerate, but not empty images.
+	for _, r := range []image.Rectangle{
+		image.Rect(0, 0, 1, 1), image.Rect(0, 0, 1, 300),
+		image.Rect(10, 10, 400, 11)} {
+		icon, err := IconE(image.NewGray(r), "")
+		if err != nil {
+			t.Errorf("Expected no error for %v, got %v.", r, err)
+		}
+		if err := icon.Validate(); err != nil {
+			t.Errorf("Expected a valid icon for %v, got %v.", r, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := sizedIcon(iconSize)
+	valid.ImgSize = Point{10, 20}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Expected a valid icon, got %v.", err)
+	}
+
+	nan := sizedIcon(iconSize)
+	nan.ImgSize = Point{10, 20}
+	nan.Pixels[5] = float32(math.NaN())
+	short := valid
+	short.Pixels = valid.Pixels[:10]
+	sized := valid
+	sized.Size = 16
+	noSize := valid
+	noSize.ImgSize = Point{0, 20}
+	large := sizedIcon(MaxIconSize + 1)
+	large.ImgSize = Point{10, 20}
+	for name, icon := range map[string]IconT{
+		"empty": EmptyIcon(), "NaN": nan, "short": short,
+		"sized": sized, "no image size": noSize, "large": large} {
+		if err := icon.Validate(); !errors.Is(err, ErrInvalidIcon) {
+			t.Errorf("Expected ErrInvalidIcon for the %s icon, got %v.",
+				name, err)
+		}
+	}
+}
+
+func TestIconRaw(t *testing.T) {
+	img, err := Open(path.Join("testdata", "euclidean", "large.jpg"))
+	if err != nil {
+		t.Fatal("Error opening image:", err)
+	}
+	icon := Icon(img, "")
+	raw := IconWithOptions(img, "", IconOptions{Raw: true})
+	if !raw.Raw || icon.Raw {
+		t.Error("Expected Raw to be set for raw icons only.")
+	}
+	if icon.Min != raw.Min || icon.Max != raw.Max {
+		t.Errorf("Expected equal ranges, got %v-%v and %v-%v.",
+			icon.Min, icon.Max, raw.Min, raw.Max)
+	}
+	// Normalization of raw pixels with recorded ranges gives
+	// the normalized icon.
+	n := iconSize * iconSize
+	for i, c := range raw.Pixels {
+		ch := i / n
+		if c < raw.Min[ch] || c > raw.Max[ch] {
+			t.Fatalf("Pixel %d value %v out of range %v-%v.",
+				i, c, raw.Min[ch], raw.Max[ch])
+		}
+		want := (c - raw.Min[ch]) * 255 / (raw.Max[ch] - raw.Min[ch])
+		if math.Abs(float64(want-icon.Pixels[i])) > 1e-3 {
+			t.Fatalf("Pixel %d: expected %v, got %v.",
+				i, want, icon.Pixels[i])
+		}
+	}
+
+	// Raw icons keep brightness differences.
+	brighter := IconWithOptions(exposed(img, 1, 40), "",
+		IconOptions{Raw: true})
+	if Similar(raw, brighter) {
+		t.Error("Expected non-similarity of raw icons of brighter image.")
+	}
+	if m1, _, _ := EucMetric(icon, raw); !math.IsInf(float64(m1), 1) {
+		t.Errorf("Expected an infinite metric of raw and normalized "+
+			"icons, got %v.", m1)
+	}
+}
@@ -1,26 +1,170 @@
 package images3
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
 	"image"
 	"image/color"
 	"image/jpeg"
 	"image/png"
+	"io"
 	"log"
 	"os"
 )
 
 // Open opens and decodes an image file for a given path.
 func Open(path string) (img image.Image, err error) {
+	return OpenWithOptions(path, OpenOptions{})
+}
+
+// OpenOptions are parameters of image opening with func
+// OpenWithOptions. The zero value opens images as func Open.
+type OpenOptions struct {
+	// Orient applies the EXIF orientation tag of JPEG images,
+	// rotating and flipping them as they are meant to be displayed.
+	// Then a photo made with a rotated phone gets the same icon as
+	// its already rotated copy.
+	Orient bool
+	// MaxPixels limits the number of pixels (width x height) of
+	// images to be decoded. 0 means DefaultMaxPixels. Negative
+	// values disable the limit.
+	MaxPixels int
+	// MaxDimension limits image width and height. 0 means
+	// DefaultMaxDimension. Negative values disable the limit.
+	MaxDimension int
+}
+
+// Default image size limits of decoding. Dimensions are checked
+// before decoding, so that a small file claiming a huge image
+// does not exhaust memory.
+const (
+	DefaultMaxPixels    = 1 << 28 // 268 megapixels.
+	DefaultMaxDimension = 1 << 16
+)
+
+// Errors of image decoding. Test for them with errors.Is.
+var (
+	ErrImageTooLarge     = errors.New("images3: image too large")
+	ErrUnsupportedFormat = errors.New("images3: unsupported image format")
+	ErrEmptyImage        = errors.New("images3: empty image")
+)
+
+// OpenWithOptions opens and decodes an image file for a given path
+// as func Open does, but with custom parameters.
+func OpenWithOptions(path string, opts OpenOptions) (img image.Image,
+	err error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
-	img, _, err = image.Decode(file)
+	img, _, err = DecodeWithOptions(file, opts)
+	return img, err
+}
+
+// ImageInfo describes a decoded image.
+type ImageInfo struct {
+	Format string          // Format name, such as "jpeg" or "png".
+	Bounds image.Rectangle // Bounds of the decoded image.
+}
+
+// Decode decodes an image from a reader, for example an HTTP body,
+// as func Open does for files. Formats are those registered with
+// package image.
+func Decode(r io.Reader) (img image.Image, info ImageInfo, err error) {
+	return DecodeWithOptions(r, OpenOptions{})
+}
+
+// Size of the stream beginning to be searched for EXIF data,
+// enough for the largest APP1 segment.
+const exifPeekSize = 1 << 17
+
+// DecodeWithOptions decodes an image from a reader as func Decode
+// does, but with custom parameters. Image size is checked against
+// limits of opts before decoding.
+func DecodeWithOptions(r io.Reader, opts OpenOptions) (img image.Image,
+	info ImageInfo, err error) {
+	orientation := 1
+	if opts.Orient {
+		// The large buffer is only needed to peek at Exif data.
+		// Peeked data can be shorter than requested with an error,
+		// e.g. for small images. Errors are left for decoding.
+		br := bufio.NewReaderSize(r, exifPeekSize)
+		head, _ := br.Peek(exifPeekSize)
+		orientation = jpegOrientation(head)
+		r = br
+	}
+
+	// Header data read by DecodeConfig is replayed for decoding.
+	var head bytes.Buffer
+	config, _, err := image.DecodeConfig(io.TeeReader(r, &head))
 	if err != nil {
-		return nil, err
+		if err == image.ErrFormat {
+			err = ErrUnsupportedFormat
+		}
+		return nil, ImageInfo{}, err
 	}
-	return img, err
+	if err = checkSize(config.Width, config.Height, opts); err != nil {
+		return nil, ImageInfo{}, err
+	}
+
+	img, info.Format, err = image.Decode(io.MultiReader(&head, r))
+	if err != nil {
+		return nil, ImageInfo{}, err
+	}
+	if img.Bounds().Empty() {
+		return nil, ImageInfo{}, ErrEmptyImage
+	}
+	img = orient(img, orientation)
+	info.Bounds = img.Bounds()
+	return img, info, nil
+}
+
+// limits returns image size limits of opts, with defaults for 0
+// values. Negative values mean no limit.
+func (opts OpenOptions) limits() (maxPixels, maxDimension int) {
+	maxPixels, maxDimension = opts.MaxPixels, opts.MaxDimension
+	if maxPixels == 0 {
+		maxPixels = DefaultMaxPixels
+	}
+	if maxDimension == 0 {
+		maxDimension = DefaultMaxDimension
+	}
+	return maxPixels, maxDimension
+}
+
+// checkSize checks image size against limits of opts.
+func checkSize(width, height int, opts OpenOptions) error {
+	if width <= 0 || height <= 0 {
+		return ErrEmptyImage
+	}
+	maxPixels, maxDimension := opts.limits()
+	if (maxDimension > 0 && (width > maxDimension || height > maxDimension)) ||
+		(maxPixels > 0 && int64(width)*int64(height) > int64(maxPixels)) {
+		return fmt.Errorf("%w: %dx%d pixels", ErrImageTooLarge, width, height)
+	}
+	return nil
+}
+
+// IconFromReader decodes an image from a reader and generates its
+// icon. id is stored as the icon Path. It also returns the decoded
+// image description.
+func IconFromReader(r io.Reader, id string) (icon IconT, info ImageInfo,
+	err error) {
+	img, info, err := Decode(r)
+	if err != nil {
+		return EmptyIcon(), ImageInfo{}, err
+	}
+	return Icon(img, id), info, nil
+}
+
+// IconFromBytes generates an icon for an encoded image in memory
+// (see func IconFromReader).
+func IconFromBytes(data []byte, id string) (icon IconT, info ImageInfo,
+	err error) {
+	return IconFromReader(bytes.NewReader(data), id)
 }
 
 // ResizeByNearest resizes an image by the nearest neighbour method to the
@@ -37,21 +181,170 @@ func ResizeByNearest(src image.Image, dstX, dstY int) (dst image.RGBA,
 	outRect := image.Rectangle{image.Point{0, 0}, image.Point{dstX, dstY}}
 	// Color model of uint8 per color.
 	dst = *image.NewRGBA(outRect)
+	if srcX <= 0 || srcY <= 0 {
+		return dst, srcX, srcY
+	}
+	at := rgbaFunc(src)
 	var (
 		r, g, b, a uint32
 	)
 	for y := 0; y < dstY; y++ {
 		for x := 0; x < dstX; x++ {
-			r, g, b, a = src.At(
+			r, g, b, a = at(
 				x*srcX/dstX+xMin,
-				y*srcY/dstY+yMin).RGBA()
-			dst.Set(x, y, color.RGBA{
-				uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)})
+				y*srcY/dstY+yMin)
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8(r >> 8)
+			dst.Pix[i+1] = uint8(g >> 8)
+			dst.Pix[i+2] = uint8(b >> 8)
+			dst.Pix[i+3] = uint8(a >> 8)
+		}
+	}
+	return dst, srcX, srcY
+}
+
+// ResizeByArea resizes an image to the output size dstX, dstY by
+// averaging source pixels over the area covered by each output pixel
+// (box filter). Source pixels crossing output pixel borders are split
+// between output pixels by covered area, so that every source pixel
+// contributes to the result exactly once in total. Unlike func
+// ResizeByNearest, it does not alias on high-frequency image content.
+// It also returns the size srcX, srcY of the input image.
+func ResizeByArea(src image.Image, dstX, dstY int) (dst image.RGBA,
+	srcX, srcY int) {
+	// Original image size.
+	xMax, xMin := src.Bounds().Max.X, src.Bounds().Min.X
+	yMax, yMin := src.Bounds().Max.Y, src.Bounds().Min.Y
+	srcX = xMax - xMin
+	srcY = yMax - yMin
+
+	// Destination rectangle.
+	outRect := image.Rectangle{image.Point{0, 0}, image.Point{dstX, dstY}}
+	// Color model of uint8 per color.
+	dst = *image.NewRGBA(outRect)
+	if srcX <= 0 || srcY <= 0 {
+		return dst, srcX, srcY
+	}
+
+	at := rgbaFunc(src)
+	wx := areaWeights(srcX, dstX)
+	wy := areaWeights(srcY, dstY)
+	// Weights of an output pixel sum up to srcX*srcY. Division
+	// by 0x101 converts 16-bit color values to 8-bit.
+	total := uint64(srcX) * uint64(srcY) * 0x101
+	var (
+		r, g, b, a             uint32
+		sumR, sumG, sumB, sumA uint64
+		w                      uint64
+	)
+	for y := 0; y < dstY; y++ {
+		for x := 0; x < dstX; x++ {
+			sumR, sumG, sumB, sumA = 0, 0, 0, 0
+			for _, cy := range wy[y] {
+				for _, cx := range wx[x] {
+					r, g, b, a = at(cx.src+xMin, cy.src+yMin)
+					w = uint64(cx.w) * uint64(cy.w)
+					sumR += uint64(r) * w
+					sumG += uint64(g) * w
+					sumB += uint64(b) * w
+					sumA += uint64(a) * w
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8((sumR + total/2) / total)
+			dst.Pix[i+1] = uint8((sumG + total/2) / total)
+			dst.Pix[i+2] = uint8((sumB + total/2) / total)
+			dst.Pix[i+3] = uint8((sumA + total/2) / total)
 		}
 	}
 	return dst, srcX, srcY
 }
 
+// areaWeight is a source pixel index and its overlap with
+// an output pixel along one axis.
+type areaWeight struct {
+	src, w int
+}
+
+// areaWeights returns for each of dstN output pixels along one axis
+// the source pixels it covers, with overlaps in units of 1/dstN of
+// a source pixel. Overlaps of one output pixel sum up to srcN,
+// and overlaps of one source pixel sum up to dstN.
+func areaWeights(srcN, dstN int) [][]areaWeight {
+	weights := make([][]areaWeight, dstN)
+	for d := 0; d < dstN; d++ {
+		// Output pixel d spans [lo, hi) in the scaled units.
+		lo, hi := d*srcN, (d+1)*srcN
+		for s := lo / dstN; s*dstN < hi; s++ {
+			start, end := s*dstN, (s+1)*dstN
+			if start < lo {
+				start = lo
+			}
+			if end > hi {
+				end = hi
+			}
+			weights[d] = append(weights[d], areaWeight{s, end - start})
+		}
+	}
+	return weights
+}
+
+// rgbaFunc returns a function giving the same color values as
+// img.At(x, y).RGBA() for points inside image bounds. For common
+// concrete image types the function reads pixel slices directly,
+// avoiding allocation of a color.Color value for every pixel.
+func rgbaFunc(img image.Image) func(x, y int) (r, g, b, a uint32) {
+	switch src := img.(type) {
+	case *image.YCbCr:
+		return func(x, y int) (r, g, b, a uint32) {
+			yi, ci := src.YOffset(x, y), src.COffset(x, y)
+			return color.YCbCr{
+				src.Y[yi], src.Cb[ci], src.Cr[ci]}.RGBA()
+		}
+	case *image.RGBA:
+		return func(x, y int) (r, g, b, a uint32) {
+			p := src.Pix[src.PixOffset(x, y):]
+			r, g, b, a = uint32(p[0]), uint32(p[1]),
+				uint32(p[2]), uint32(p[3])
+			return r | r<<8, g | g<<8, b | b<<8, a | a<<8
+		}
+	case *image.NRGBA:
+		return func(x, y int) (r, g, b, a uint32) {
+			p := src.Pix[src.PixOffset(x, y):]
+			return color.NRGBA{p[0], p[1], p[2], p[3]}.RGBA()
+		}
+	case *image.Gray:
+		return func(x, y int) (r, g, b, a uint32) {
+			v := uint32(src.Pix[src.PixOffset(x, y)])
+			v |= v << 8
+			return v, v, v, 0xffff
+		}
+	case orientedImage:
+		at := rgbaFunc(src.src)
+		return func(x, y int) (r, g, b, a uint32) {
+			return at(src.srcPoint(x, y))
+		}
+	case *image.Paletted:
+		// Palette colors are converted once. Indices out of palette
+		// range, which make func At panic, give transparent color.
+		var palette [256][4]uint32
+		for i, c := range src.Palette {
+			if i == len(palette) {
+				break
+			}
+			r, g, b, a := c.RGBA()
+			palette[i] = [4]uint32{r, g, b, a}
+		}
+		return func(x, y int) (r, g, b, a uint32) {
+			c := palette[src.Pix[src.PixOffset(x, y)]]
+			return c[0], c[1], c[2], c[3]
+		}
+	}
+	return func(x, y int) (r, g, b, a uint32) {
+		return img.At(x, y).RGBA()
+	}
+}
+
 // SaveToPNG encodes and saves image.RGBA to a file.
 func SaveToPNG(img *image.RGBA, path string) {
 	if destFile, err := os.Create(path); err != nil {
@@ -1,10 +1,17 @@
 package images3
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
 	"image"
+	"io"
+	"os"
 	"path"
 	"reflect"
 	"testing"
+	"testing/iotest"
 )
 
 const (
@@ -46,3 +53,265 @@ func TestResizeByNearest(t *testing.T) {
 		}
 	}
 }
+
+func TestResizeByArea(t *testing.T) {
+	testDir := path.Join(testDir1, testDir2)
+
+	// Same size resizing must reproduce the image.
+	inImg, err := Open(path.Join(testDir, "original.png"))
+	if err != nil {
+		t.Error("Cannot decode", path.Join(testDir, "original.png"))
+	}
+	resampled, srcX, srcY := ResizeByArea(inImg, 533, 400)
+	if srcX != 533 || srcY != 400 {
+		t.Errorf("Expected source size (533, 400), got (%d, %d).",
+			srcX, srcY)
+	}
+	for y := 0; y < 400; y++ {
+		for x := 0; x < 533; x++ {
+			r1, g1, b1, a1 := inImg.At(x, y).RGBA()
+			r2, g2, b2, a2 := resampled.At(x, y).RGBA()
+			if r1>>8 != r2>>8 || g1>>8 != g2>>8 ||
+				b1>>8 != b2>>8 || a1>>8 != a2>>8 {
+				t.Fatalf("Pixel (%d, %d) mismatch.", x, y)
+			}
+		}
+	}
+
+	// Halving must average 2x2 blocks.
+	inImg, err = Open(path.Join(testDir, "nearest100x100.png"))
+	if err != nil {
+		t.Error("Cannot decode", path.Join(testDir, "nearest100x100.png"))
+	}
+	resampled, _, _ = ResizeByArea(inImg, 50, 50)
+	for y := 0; y < 50; y++ {
+		for x := 0; x < 50; x++ {
+			var sum [3]uint32
+			for _, p := range []image.Point{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+				r, g, b, _ := inImg.At(2*x+p.X, 2*y+p.Y).RGBA()
+				sum[0] += r >> 8
+				sum[1] += g >> 8
+				sum[2] += b >> 8
+			}
+			c := resampled.RGBAAt(x, y)
+			got := [3]uint32{uint32(c.R), uint32(c.G), uint32(c.B)}
+			for ch := range sum {
+				want := (sum[ch] + 2) / 4
+				if got[ch] < want-1 || got[ch] > want+1 {
+					t.Fatalf("Pixel (%d, %d) channel %d: want %d, got %d.",
+						x, y, ch, want, got[ch])
+				}
+			}
+		}
+	}
+}
+
+func TestAreaWeights(t *testing.T) {
+	for _, n := range [][2]int{{533, 276}, {100, 276}, {400, 7}, {7, 7}} {
+		srcN, dstN := n[0], n[1]
+		perSrc := make([]int, srcN)
+		for d, ws := range areaWeights(srcN, dstN) {
+			sum := 0
+			for _, w := range ws {
+				sum += w.w
+				perSrc[w.src] += w.w
+			}
+			if sum != srcN {
+				t.Errorf("Output pixel %d of %v: weights sum to %d.",
+					d, n, sum)
+			}
+		}
+		for s, sum := range perSrc {
+			if sum != dstN {
+				t.Errorf("Source pixel %d of %v: weights sum to %d.",
+					s, n, sum)
+			}
+		}
+	}
+}
+
+// Pixel-wide stripes alias with nearest neighbour resizing,
+// but average to uniform gray with area resizing.
+func TestResizeByAreaAliasing(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 1104, 999))
+	for y := 0; y < 999; y++ {
+		for x := 0; x < 1104; x += 2 {
+			src.Pix[src.PixOffset(x, y)] = 255
+		}
+	}
+	dst, _, _ := ResizeByNearest(src, 276, 276)
+	for i := 0; i < len(dst.Pix); i += 4 {
+		if dst.Pix[i] != 255 {
+			t.Fatalf("Expected aliasing to white, got %d.", dst.Pix[i])
+		}
+	}
+	dst, _, _ = ResizeByArea(src, 276, 276)
+	for i := 0; i < len(dst.Pix); i += 4 {
+		if dst.Pix[i] != 128 {
+			t.Fatalf("Expected uniform gray, got %d.", dst.Pix[i])
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	for _, tc := range []struct {
+		file, format string
+		bounds       image.Rectangle
+	}{
+		{"euclidean/large.jpg", "jpeg", image.Rect(0, 0, 533, 400)},
+		{"resample/original.png", "png", image.Rect(0, 0, 533, 400)},
+		{"orientation/6.jpg", "jpeg", image.Rect(0, 0, 400, 533)},
+	} {
+		filePath := path.Join(testDir1, tc.file)
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			t.Fatal("Error reading file:", err)
+		}
+		img, info, err := Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal("Error decoding image:", err)
+		}
+		if info.Format != tc.format || info.Bounds != tc.bounds ||
+			img.Bounds() != tc.bounds {
+			t.Errorf("%s: expected %s %v, got %s %v.",
+				tc.file, tc.format, tc.bounds, info.Format, info.Bounds)
+		}
+		// The same decoding as with Open.
+		want, err := Open(filePath)
+		if err != nil {
+			t.Fatal("Error opening image:", err)
+		}
+		if !reflect.DeepEqual(img, want) {
+			t.Errorf("%s: images of Decode and Open differ.", tc.file)
+		}
+		icon, info2, err := IconFromBytes(data, "id")
+		if err != nil {
+			t.Fatal("Error making icon:", err)
+		}
+		if info2 != info || icon.Path != "id" ||
+			!reflect.DeepEqual(icon, Icon(want, "id")) {
+			t.Errorf("%s: unexpected icon from bytes.", tc.file)
+		}
+	}
+	// Orientation is applied from the stream.
+	f, err := os.Open(path.Join(testDir1, "orientation", "6.jpg"))
+	if err != nil {
+		t.Fatal("Error opening file:", err)
+	}
+	defer f.Close()
+	_, info, err := DecodeWithOptions(f, OpenOptions{Orient: true})
+	if err != nil || info.Bounds != image.Rect(0, 0, 533, 400) {
+		t.Errorf("Expected oriented bounds, got %v, %v.", info.Bounds, err)
+	}
+}
+
+func TestDecodeCorrupt(t *testing.T) {
+	for _, file := range []string{
+		"euclidean/large.jpg", "resample/original.png"} {
+		data, err := os.ReadFile(path.Join(testDir1, file))
+		if err != nil {
+			t.Fatal("Error reading file:", err)
+		}
+		// Truncated streams.
+		for _, n := range []int{0, 1, 8, 100, len(data) / 3,
+			len(data) / 2, len(data) - 10} {
+			if _, _, err := Decode(bytes.NewReader(data[:n])); err == nil {
+				t.Errorf("%s: expected an error for %d bytes.", file, n)
+			}
+			icon, _, err := IconFromReader(
+				bytes.NewReader(data[:n]), "")
+			if err == nil || icon.Pixels != nil {
+				t.Errorf("%s: expected an error and an empty icon "+
+					"for %d bytes.", file, n)
+			}
+		}
+		// Corrupt headers.
+		corrupt := append([]byte{}, data...)
+		for i := 0; i < 16; i++ {
+			corrupt[i] ^= 0x5a
+		}
+		if _, _, err := Decode(bytes.NewReader(corrupt)); err == nil {
+			t.Errorf("%s: expected an error for corrupt data.", file)
+		}
+		// Reader errors.
+		errRead := errors.New("read error")
+		r := io.MultiReader(bytes.NewReader(data[:1000]),
+			iotest.ErrReader(errRead))
+		if _, _, err := Decode(r); !errors.Is(err, errRead) {
+			t.Errorf("%s: expected the reader error, got %v.", file, err)
+		}
+	}
+}
+
+// pngHeader returns PNG data with no image data, but a header
+// claiming image size width x height.
+func pngHeader(width, height uint32) []byte {
+	ihdr := make([]byte, 17)
+	copy(ihdr, "IHDR")
+	binary.BigEndian.PutUint32(ihdr[4:], width)
+	binary.BigEndian.PutUint32(ihdr[8:], height)
+	ihdr[12] = 8 // Bit depth.
+	ihdr[13] = 2 // Truecolor.
+	data := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0d")
+	data = append(data, ihdr...)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(ihdr))
+	return append(data, crc...)
+}
+
+func TestDecodeLimits(t *testing.T) {
+	// Headers of huge images are rejected before decoding.
+	for _, size := range []Point{{50000, 50000}, {100000, 10}} {
+		data := pngHeader(uint32(size.X), uint32(size.Y))
+		_, _, err := Decode(bytes.NewReader(data))
+		if !errors.Is(err, ErrImageTooLarge) {
+			t.Errorf("Expected ErrImageTooLarge for %v, got %v.", size, err)
+		}
+	}
+
+	// Custom limits.
+	data, err := os.ReadFile(path.Join(testDir1, "euclidean/large.jpg"))
+	if err != nil {
+		t.Fatal("Error reading file:", err)
+	}
+	for _, opts := range []OpenOptions{
+		{MaxPixels: 1000}, {MaxDimension: 100}} {
+		_, _, err := DecodeWithOptions(bytes.NewReader(data), opts)
+		if !errors.Is(err, ErrImageTooLarge) {
+			t.Errorf("Expected ErrImageTooLarge for %+v, got %v.", opts, err)
+		}
+	}
+	for _, opts := range []OpenOptions{
+		{MaxPixels: -1, MaxDimension: -1}, {MaxPixels: 1 << 24}} {
+		_, info, err := DecodeWithOptions(bytes.NewReader(data), opts)
+		if err != nil || info.Bounds.Empty() {
+			t.Errorf("Expected decoding for %+v, got %v.", opts, err)
+		}
+	}
+
+	// Disabled limits let the decoder fail on missing image data.
+	_, _, err = DecodeWithOptions(bytes.NewReader(pngHeader(50000, 50000)),
+		OpenOptions{MaxPixels: -1})
+	if err == nil || errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("Expected a decoding error, got %v.", err)
+	}
+}
+
+func TestDecodeFormatErrors(t *testing.T) {
+	_, _, err := Decode(bytes.NewReader([]byte("not an image")))
+	if !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Expected ErrUnsupportedFormat, got %v.", err)
+	}
+	_, err = Open(path.Join(testDir1, "euclidean", "missing.jpg"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected os.ErrNotExist, got %v.", err)
+	}
+
+	// Empty images are rejected before decoding.
+	for _, size := range []Point{{0, 0}, {0, 5}, {5, 0}} {
+		err = checkSize(size.X, size.Y, OpenOptions{})
+		if !errors.Is(err, ErrEmptyImage) {
+			t.Errorf("Expected ErrEmptyImage for %v, got %v.", size, err)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package images3
+
+import (
+	"sort"
+	"sync"
+)
+
+// IndexOptions are hash parameters of an Index (see funcs
+// CentralHash and HashSet). The zero value means the defaults.
+type IndexOptions struct {
+	// Points are hyper points of the default 11x11 icon.
+	// Default is HyperPoints10. For icons of other sizes
+	// the points are scaled with func ScalePoints.
+	Points []Point
+	// EpsPercent is the hyper space rescaling coefficient.
+	// 0 means the default of 0.25.
+	EpsPercent float64
+	// NumBuckets is the number of buckets per hyper space
+	// dimension. 0 means the default of 4.
+	NumBuckets int
+}
+
+// Index is an in-memory hash table of icons for fast search of
+// similar images in large collections. Icons are stored with
+// their central hashes, and queried with hash sets, so that only
+// icons with matching hashes are compared with func Similar.
+// Index is safe for concurrent use.
+type Index struct {
+	opts    IndexOptions
+	mu      sync.RWMutex
+	hashes  map[uint64][]string // Central hash to ids.
+	entries map[string]indexEntry
+}
+
+// indexEntry is an icon stored in the index.
+type indexEntry struct {
+	icon IconT
+	hash uint64
+}
+
+// Candidate is an image found in the index.
+type Candidate struct {
+	ID   string
+	Icon IconT
+}
+
+// NewIndex creates an empty index with hash parameters opts.
+func NewIndex(opts IndexOptions) *Index {
+	if opts.Points == nil {
+		opts.Points = HyperPoints10
+	}
+	if opts.EpsPercent <= 0 {
+		opts.EpsPercent = 0.25
+	}
+	if opts.NumBuckets <= 0 {
+		opts.NumBuckets = 4
+	}
+	return &Index{
+		opts:    opts,
+		hashes:  make(map[uint64][]string),
+		entries: make(map[string]indexEntry)}
+}
+
+// points returns hyper points for icons of the given size.
+func (ix *Index) points(size int) []Point {
+	if size == iconSize {
+		return ix.opts.Points
+	}
+	return ScalePoints(ix.opts.Points, size)
+}
+
+// Add adds an icon to the index with image identifier id,
+// replacing an icon added before with the same id. Invalid icons
+// (see func Validate), such as EmptyIcon of failed decoding, are
+// not added and give ErrInvalidIcon.
+func (ix *Index) Add(id string, icon IconT) error {
+	if err := icon.Validate(); err != nil {
+		return err
+	}
+	hash := CentralHash(icon, ix.points(icon.size()),
+		ix.opts.EpsPercent, ix.opts.NumBuckets)
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(id)
+	ix.entries[id] = indexEntry{icon, hash}
+	ix.hashes[hash] = append(ix.hashes[hash], id)
+	return nil
+}
+
+// Remove removes the icon with identifier id from the index.
+// It returns false if there is no such icon.
+func (ix *Index) Remove(id string) bool {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	return ix.remove(id)
+}
+
+// remove is Remove without locking.
+func (ix *Index) remove(id string) bool {
+	entry, ok := ix.entries[id]
+	if !ok {
+		return false
+	}
+	delete(ix.entries, id)
+	ids := ix.hashes[entry.hash]
+	for i := range ids {
+		if ids[i] == id {
+			ids = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(ids) == 0 {
+		delete(ix.hashes, entry.hash)
+	} else {
+		ix.hashes[entry.hash] = ids
+	}
+	return true
+}
+
+// Len returns the number of icons in the index.
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return len(ix.entries)
+}
+
+// Query returns indexed images similar to the query icon by
+// func Similar, sorted by id. Candidates are found by hash set
+// of the query, so that the result is a fast approximation of
+// comparison with all indexed icons, which can miss some similar
+// images with luma values close to hash bucket borders. Invalid
+// icons (see func Validate) have no candidates.
+func (ix *Index) Query(icon IconT) []Candidate {
+	if icon.Validate() != nil {
+		return nil
+	}
+	hashSet := HashSet(icon, ix.points(icon.size()),
+		ix.opts.EpsPercent, ix.opts.NumBuckets)
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	seen := make(map[string]bool)
+	var found []Candidate
+	for _, hash := range hashSet {
+		for _, id := range ix.hashes[hash] {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			if entry := ix.entries[id]; Similar(icon, entry.icon) {
+				found = append(found, Candidate{id, entry.icon})
+			}
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].ID < found[j].ID
+	})
+	return found
+}
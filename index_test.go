@@ -0,0 +1,160 @@
+package images3
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	icons := testdataIcons(t)
+	ix := NewIndex(IndexOptions{})
+	for i, icon := range icons {
+		if err := ix.Add(fmt.Sprint(i), icon); err != nil {
+			t.Fatal("Error adding icon:", err)
+		}
+	}
+	if ix.Len() != len(icons) {
+		t.Fatalf("Expected %d icons, got %d.", len(icons), ix.Len())
+	}
+
+	// Query results are similar icons, and include the query icon.
+	for i, icon := range icons {
+		similar := make(map[string]bool)
+		for _, j := range FindSimilar(icon, icons) {
+			similar[fmt.Sprint(j)] = true
+		}
+		found := ix.Query(icon)
+		self := false
+		for k, c := range found {
+			if !similar[c.ID] {
+				t.Errorf("Unexpected candidate %s for %d.", c.ID, i)
+			}
+			if k > 0 && found[k-1].ID >= c.ID {
+				t.Errorf("Expected candidates sorted by id, got %v, %v.",
+					found[k-1].ID, c.ID)
+			}
+			self = self || c.ID == fmt.Sprint(i)
+		}
+		if !self {
+			t.Errorf("Expected icon %d among its candidates.", i)
+		}
+	}
+
+	// Near duplicates.
+	p := path.Join("testdata", "euclidean")
+	ix = NewIndex(IndexOptions{})
+	for _, name := range []string{"small.jpg", "distorted.jpg",
+		"flipped.jpg"} {
+		img, err := Open(path.Join(p, name))
+		if err != nil {
+			t.Fatal("Error opening image:", err)
+		}
+		if err := ix.Add(name, Icon(img, name)); err != nil {
+			t.Fatal("Error adding icon:", err)
+		}
+	}
+	img, err := Open(path.Join(p, "large.jpg"))
+	if err != nil {
+		t.Fatal("Error opening image:", err)
+	}
+	query := Icon(img, "")
+	// Proportions of distorted.jpg differ.
+	if got := candidateIDs(ix.Query(query)); got != "[small.jpg]" {
+		t.Errorf("Expected small.jpg, got %v.", got)
+	}
+
+	// Removal and replacement.
+	if !ix.Remove("small.jpg") || ix.Remove("small.jpg") {
+		t.Error("Expected removal of small.jpg once.")
+	}
+	if got := candidateIDs(ix.Query(query)); got != "[]" {
+		t.Errorf("Expected no candidates, got %v.", got)
+	}
+	if err := ix.Add("flipped.jpg", query); err != nil {
+		t.Fatal("Error adding icon:", err)
+	}
+	if got := candidateIDs(ix.Query(query)); got != "[flipped.jpg]" {
+		t.Errorf("Expected the replaced flipped.jpg, got %v.", got)
+	}
+	if ix.Len() != 2 {
+		t.Errorf("Expected 2 icons, got %d.", ix.Len())
+	}
+}
+
+func candidateIDs(candidates []Candidate) string {
+	ids := make([]string, len(candidates))
+	for i := range candidates {
+		ids[i] = candidates[i].ID
+	}
+	return fmt.Sprint(ids)
+}
+
+func TestIndexSized(t *testing.T) {
+	img, err := Open(path.Join("testdata", "euclidean", "large.jpg"))
+	if err != nil {
+		t.Fatal("Error opening image:", err)
+	}
+	small, err := Open(path.Join("testdata", "euclidean", "small.jpg"))
+	if err != nil {
+		t.Fatal("Error opening image:", err)
+	}
+	opts := IconOptions{Size: 16}
+	ix := NewIndex(IndexOptions{EpsPercent: 0.3, NumBuckets: 5,
+		Points: HyperPoints10})
+	if err := ix.Add("small", IconWithOptions(small, "", opts)); err != nil {
+		t.Fatal("Error adding icon:", err)
+	}
+	if got := candidateIDs(ix.Query(IconWithOptions(img, "", opts))); got != "[small]" {
+		t.Errorf("Expected small, got %v.", got)
+	}
+}
+
+// Icons of failed decoding and other invalid icons are not indexed.
+func TestIndexInvalid(t *testing.T) {
+	ix, icons := testIndex(t, IndexOptions{})
+	short := icons[0]
+	short.Pixels = short.Pixels[:10]
+	for name, icon := range map[string]IconT{
+		"empty": EmptyIcon(), "short": short} {
+		if err := ix.Add(name, icon); !errors.Is(err, ErrInvalidIcon) {
+			t.Errorf("Expected ErrInvalidIcon for the %s icon, got %v.",
+				name, err)
+		}
+		if found := ix.Query(icon); found != nil {
+			t.Errorf("Expected no candidates for the %s icon, got %v.",
+				name, candidateIDs(found))
+		}
+	}
+	if ix.Len() != len(icons) {
+		t.Errorf("Expected %d icons, got %d.", len(icons), ix.Len())
+	}
+}
+
+func TestIndexConcurrency(t *testing.T) {
+	icons := testdataIcons(t)
+	ix := NewIndex(IndexOptions{})
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i, icon := range icons {
+				id := fmt.Sprint(w, "-", i)
+				if err := ix.Add(id, icon); err != nil {
+					t.Error("Error adding icon:", err)
+				}
+				ix.Query(icon)
+				if i%2 == 0 {
+					ix.Remove(id)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	if want := 4 * (len(icons) / 2); ix.Len() != want {
+		t.Errorf("Expected %d icons, got %d.", want, ix.Len())
+	}
+}
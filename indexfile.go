@@ -0,0 +1,323 @@
+package images3
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"sort"
+)
+
+// Index file format. All numbers are little-endian. The header is
+//
+//	magic      [4]byte  "IMGX"
+//	version    uint8
+//	numBuckets uint16
+//	epsPercent float64
+//	numPoints  uint16
+//	points     [numPoints][2]int32
+//	crc        uint32   CRC-32 (IEEE) of the above
+//
+// followed by records
+//
+//	type       uint8
+//	length     uint32
+//	payload    [length]byte
+//	crc        uint32   CRC-32 (IEEE) of type, length and payload
+//
+// Record payloads are
+//
+//	recordAdd:       idLen uint16, id, central hash uint64, icon
+//	                 (see func MarshalBinary)
+//	recordTombstone: id
+//	recordEnd:       number of preceding records uint64
+//
+// Func Save writes a snapshot of the index ending with a recordEnd,
+// so that truncated snapshots are detected. Append methods write
+// single records after it.
+const (
+	indexMagic   = "IMGX"
+	indexVersion = 1
+
+	recordAdd       = 1
+	recordTombstone = 2
+	recordEnd       = 3
+
+	// Maximal record payload length, which limits allocation
+	// for corrupt data.
+	maxRecordLen = 1 << 24
+)
+
+// ErrIndexData is the error of reading corrupt or truncated
+// index data. Test for it with errors.Is.
+var ErrIndexData = errors.New("images3: invalid index data")
+
+// Save writes the index in the index file format: the header with
+// hash parameters and records of all icons sorted by id. Icons are
+// loaded with func LoadIndex.
+func (ix *Index) Save(w io.Writer) error {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	bw := bufio.NewWriter(w)
+	if err := ix.writeHeader(bw); err != nil {
+		return err
+	}
+	ids := make([]string, 0, len(ix.entries))
+	for id := range ix.entries {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		entry := ix.entries[id]
+		if err := writeAdd(bw, id, entry.icon, entry.hash); err != nil {
+			return err
+		}
+	}
+	end := make([]byte, 8)
+	binary.LittleEndian.PutUint64(end, uint64(len(ids)))
+	if err := writeRecord(bw, recordEnd, end); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// AppendAdd adds an icon to the index as func Add does, and writes
+// the update to w, which is usually an index file opened for
+// appending. Then the file need not be rewritten with func Save
+// after every update. Invalid icons give ErrInvalidIcon, and are
+// neither added nor written.
+func (ix *Index) AppendAdd(w io.Writer, id string, icon IconT) error {
+	if err := icon.Validate(); err != nil {
+		return err
+	}
+	hash := CentralHash(icon, ix.points(icon.size()),
+		ix.opts.EpsPercent, ix.opts.NumBuckets)
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if err := writeAdd(w, id, icon, hash); err != nil {
+		return err
+	}
+	ix.remove(id)
+	ix.entries[id] = indexEntry{icon, hash}
+	ix.hashes[hash] = append(ix.hashes[hash], id)
+	return nil
+}
+
+// AppendRemove removes an icon from the index as func Remove does,
+// and writes a tombstone record of the removal to w (see func
+// AppendAdd). Nothing is written if there is no such icon.
+func (ix *Index) AppendRemove(w io.Writer, id string) (bool, error) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if _, ok := ix.entries[id]; !ok {
+		return false, nil
+	}
+	if err := writeRecord(w, recordTombstone, []byte(id)); err != nil {
+		return false, err
+	}
+	return ix.remove(id), nil
+}
+
+// LoadIndex reads an index written by func Save and append methods.
+// Later records replace and remove icons of earlier ones. Corrupt
+// data, including a truncated snapshot of func Save or a truncated
+// record, give ErrIndexData and no index.
+func LoadIndex(r io.Reader) (*Index, error) {
+	br := bufio.NewReader(r)
+	opts, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	ix := NewIndex(opts)
+	var count uint64
+	ended := false
+	for {
+		typ, payload, err := readRecord(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case recordAdd:
+			id, hash, icon, err := parseAdd(payload)
+			if err != nil {
+				return nil, err
+			}
+			ix.remove(id)
+			ix.entries[id] = indexEntry{icon, hash}
+			ix.hashes[hash] = append(ix.hashes[hash], id)
+		case recordTombstone:
+			ix.remove(string(payload))
+		case recordEnd:
+			if len(payload) != 8 ||
+				binary.LittleEndian.Uint64(payload) != count {
+				return nil, fmt.Errorf("%w: snapshot of %d records",
+					ErrIndexData, count)
+			}
+			ended = true
+		default:
+			return nil, fmt.Errorf("%w: record type %d", ErrIndexData, typ)
+		}
+		if !ended {
+			count++
+		}
+	}
+	if !ended {
+		return nil, fmt.Errorf("%w: truncated snapshot", ErrIndexData)
+	}
+	return ix, nil
+}
+
+// CompactIndex reads an index from src and writes it to dst with
+// func Save, dropping replaced icons and tombstones of removed ones.
+func CompactIndex(dst io.Writer, src io.Reader) error {
+	ix, err := LoadIndex(src)
+	if err != nil {
+		return err
+	}
+	return ix.Save(dst)
+}
+
+// writeHeader writes the index file header. Hash parameters not
+// fitting the format are an error, as they would not be loaded
+// back unchanged.
+func (ix *Index) writeHeader(w io.Writer) error {
+	if ix.opts.NumBuckets > math.MaxUint16 {
+		return fmt.Errorf("images3: index of %d buckets", ix.opts.NumBuckets)
+	}
+	if len(ix.opts.Points) > math.MaxUint16 {
+		return fmt.Errorf("images3: index of %d points", len(ix.opts.Points))
+	}
+	for _, point := range ix.opts.Points {
+		if point.X != int(int32(point.X)) || point.Y != int(int32(point.Y)) {
+			return fmt.Errorf("images3: index point %v", point)
+		}
+	}
+	le := binary.LittleEndian
+	header := make([]byte, 17, 17+8*len(ix.opts.Points)+4)
+	copy(header, indexMagic)
+	header[4] = indexVersion
+	le.PutUint16(header[5:], uint16(ix.opts.NumBuckets))
+	le.PutUint64(header[7:], math.Float64bits(ix.opts.EpsPercent))
+	le.PutUint16(header[15:], uint16(len(ix.opts.Points)))
+	p := make([]byte, 8)
+	for _, point := range ix.opts.Points {
+		le.PutUint32(p, uint32(int32(point.X)))
+		le.PutUint32(p[4:], uint32(int32(point.Y)))
+		header = append(header, p...)
+	}
+	le.PutUint32(p, crc32.ChecksumIEEE(header))
+	header = append(header, p[:4]...)
+	_, err := w.Write(header)
+	return err
+}
+
+// readHeader reads the index file header.
+func readHeader(r io.Reader) (opts IndexOptions, err error) {
+	header := make([]byte, 17)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return opts, fmt.Errorf("%w: header: %v", ErrIndexData, err)
+	}
+	if string(header[:4]) != indexMagic {
+		return opts, fmt.Errorf("%w: no header", ErrIndexData)
+	}
+	if header[4] != indexVersion {
+		return opts, fmt.Errorf("%w: version %d", ErrIndexData, header[4])
+	}
+	le := binary.LittleEndian
+	rest := make([]byte, 8*int(le.Uint16(header[15:]))+4)
+	if _, err = io.ReadFull(r, rest); err != nil {
+		return opts, fmt.Errorf("%w: header: %v", ErrIndexData, err)
+	}
+	points, sum := rest[:len(rest)-4], le.Uint32(rest[len(rest)-4:])
+	if crc32.Update(crc32.ChecksumIEEE(header),
+		crc32.IEEETable, points) != sum {
+		return opts, fmt.Errorf("%w: header checksum", ErrIndexData)
+	}
+	opts.NumBuckets = int(le.Uint16(header[5:]))
+	opts.EpsPercent = math.Float64frombits(le.Uint64(header[7:]))
+	opts.Points = make([]Point, len(points)/8)
+	for i := range opts.Points {
+		opts.Points[i] = Point{
+			int(int32(le.Uint32(points[8*i:]))),
+			int(int32(le.Uint32(points[8*i+4:])))}
+	}
+	return opts, nil
+}
+
+// writeAdd writes a record of an added icon.
+func writeAdd(w io.Writer, id string, icon IconT, hash uint64) error {
+	if len(id) > math.MaxUint16 {
+		return fmt.Errorf("images3: index id of %d bytes", len(id))
+	}
+	data, err := icon.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	payload := make([]byte, 2+len(id)+8, 2+len(id)+8+len(data))
+	binary.LittleEndian.PutUint16(payload, uint16(len(id)))
+	copy(payload[2:], id)
+	binary.LittleEndian.PutUint64(payload[2+len(id):], hash)
+	return writeRecord(w, recordAdd, append(payload, data...))
+}
+
+// parseAdd parses the payload of an add record.
+func parseAdd(payload []byte) (id string, hash uint64, icon IconT,
+	err error) {
+	if len(payload) < 2 {
+		return "", 0, icon, fmt.Errorf("%w: add record", ErrIndexData)
+	}
+	n := int(binary.LittleEndian.Uint16(payload))
+	if len(payload) < 2+n+8 {
+		return "", 0, icon, fmt.Errorf("%w: add record", ErrIndexData)
+	}
+	id = string(payload[2 : 2+n])
+	hash = binary.LittleEndian.Uint64(payload[2+n:])
+	if err = icon.UnmarshalBinary(payload[2+n+8:]); err != nil {
+		return "", 0, icon, fmt.Errorf("%w: %v", ErrIndexData, err)
+	}
+	return id, hash, icon, nil
+}
+
+// writeRecord writes a record with its checksum in a single write.
+func writeRecord(w io.Writer, typ byte, payload []byte) error {
+	record := make([]byte, 5+len(payload)+4)
+	record[0] = typ
+	binary.LittleEndian.PutUint32(record[1:], uint32(len(payload)))
+	copy(record[5:], payload)
+	binary.LittleEndian.PutUint32(record[5+len(payload):],
+		crc32.ChecksumIEEE(record[:5+len(payload)]))
+	_, err := w.Write(record)
+	return err
+}
+
+// readRecord reads a record and checks its checksum. It returns
+// io.EOF only at the end of data between records.
+func readRecord(r io.Reader) (typ byte, payload []byte, err error) {
+	head := make([]byte, 5)
+	if _, err = io.ReadFull(r, head); err != nil {
+		if err == io.EOF {
+			return 0, nil, err
+		}
+		return 0, nil, fmt.Errorf("%w: record: %v", ErrIndexData, err)
+	}
+	n := binary.LittleEndian.Uint32(head[1:])
+	if n > maxRecordLen {
+		return 0, nil, fmt.Errorf("%w: record length %d", ErrIndexData, n)
+	}
+	rest := make([]byte, n+4)
+	if _, err = io.ReadFull(r, rest); err != nil {
+		return 0, nil, fmt.Errorf("%w: record: %v", ErrIndexData, err)
+	}
+	payload = rest[:n]
+	sum := crc32.Update(crc32.ChecksumIEEE(head), crc32.IEEETable, payload)
+	if sum != binary.LittleEndian.Uint32(rest[n:]) {
+		return 0, nil, fmt.Errorf("%w: record checksum", ErrIndexData)
+	}
+	return head[0], payload, nil
+}
@@ -0,0 +1,212 @@
+package images3
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testIndex returns an index of testdata icons with ids "0", "1"...
+func testIndex(t *testing.T, opts IndexOptions) (*Index, []IconT) {
+	icons := testdataIcons(t)
+	ix := NewIndex(opts)
+	for i, icon := range icons {
+		if err := ix.Add(fmt.Sprint(i), icon); err != nil {
+			t.Fatal("Error adding icon:", err)
+		}
+	}
+	return ix, icons
+}
+
+// sameIndex checks that 2 indexes have the same parameters, icons
+// and query results.
+func sameIndex(t *testing.T, got, want *Index, queries []IconT) {
+	t.Helper()
+	if !reflect.DeepEqual(got.opts, want.opts) {
+		t.Fatalf("Expected options %+v, got %+v.", want.opts, got.opts)
+	}
+	if !reflect.DeepEqual(got.entries, want.entries) {
+		t.Fatalf("Expected %d icons, got %d different.",
+			len(want.entries), len(got.entries))
+	}
+	for _, q := range queries {
+		if !reflect.DeepEqual(got.Query(q), want.Query(q)) {
+			t.Fatalf("Expected equal query results for %s.", q.Path)
+		}
+	}
+}
+
+func TestSaveLoadIndex(t *testing.T) {
+	for _, opts := range []IndexOptions{{},
+		{Points: fourPoints(), EpsPercent: 0.3, NumBuckets: 5}} {
+		ix, icons := testIndex(t, opts)
+		var buf bytes.Buffer
+		if err := ix.Save(&buf); err != nil {
+			t.Fatal("Error saving index:", err)
+		}
+		loaded, err := LoadIndex(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal("Error loading index:", err)
+		}
+		sameIndex(t, loaded, ix, icons)
+	}
+
+	// Empty index.
+	var buf bytes.Buffer
+	if err := NewIndex(IndexOptions{}).Save(&buf); err != nil {
+		t.Fatal("Error saving index:", err)
+	}
+	if ix, err := LoadIndex(&buf); err != nil || ix.Len() != 0 {
+		t.Errorf("Expected an empty index, got %v.", err)
+	}
+}
+
+// fourPoints returns 4 hyper points.
+func fourPoints() []Point {
+	return []Point{{2, 2}, {2, 8}, {8, 2}, {8, 8}}
+}
+
+func TestAppendIndex(t *testing.T) {
+	ix, icons := testIndex(t, IndexOptions{})
+	file := filepath.Join(t.TempDir(), "index")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal("Error creating file:", err)
+	}
+	if err := ix.Save(f); err != nil {
+		t.Fatal("Error saving index:", err)
+	}
+	f.Close()
+
+	// Updates appended to the file.
+	f, err = os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal("Error opening file:", err)
+	}
+	for i := 0; i < 10; i++ {
+		if ok, err := ix.AppendRemove(f, fmt.Sprint(i)); !ok || err != nil {
+			t.Fatalf("Expected removal of %d, got %v, %v.", i, ok, err)
+		}
+	}
+	if ok, err := ix.AppendRemove(f, "missing"); ok || err != nil {
+		t.Errorf("Expected no removal, got %v, %v.", ok, err)
+	}
+	for i := 10; i < 15; i++ {
+		if err := ix.AppendAdd(f, fmt.Sprint(i), icons[0]); err != nil {
+			t.Fatal("Error appending icon:", err)
+		}
+	}
+	if err := ix.AppendAdd(f, "new", icons[1]); err != nil {
+		t.Fatal("Error appending icon:", err)
+	}
+	f.Close()
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal("Error reading file:", err)
+	}
+	loaded, err := LoadIndex(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal("Error loading index:", err)
+	}
+	sameIndex(t, loaded, ix, icons)
+	if loaded.Len() != len(icons)-10+1 {
+		t.Errorf("Expected %d icons, got %d.", len(icons)-9, loaded.Len())
+	}
+
+	// Compaction drops tombstones and replaced icons.
+	var compacted bytes.Buffer
+	if err := CompactIndex(&compacted, bytes.NewReader(data)); err != nil {
+		t.Fatal("Error compacting index:", err)
+	}
+	if compacted.Len() >= len(data) {
+		t.Errorf("Expected less than %d bytes, got %d.",
+			len(data), compacted.Len())
+	}
+	loaded, err = LoadIndex(&compacted)
+	if err != nil {
+		t.Fatal("Error loading compacted index:", err)
+	}
+	sameIndex(t, loaded, ix, icons)
+}
+
+func TestAppendAddInvalid(t *testing.T) {
+	ix, icons := testIndex(t, IndexOptions{})
+	var buf bytes.Buffer
+	if err := ix.AppendAdd(&buf, "empty", EmptyIcon()); !errors.Is(err, ErrInvalidIcon) {
+		t.Errorf("Expected ErrInvalidIcon, got %v.", err)
+	}
+	if buf.Len() != 0 || ix.Len() != len(icons) {
+		t.Errorf("Expected nothing written and %d icons, got %d bytes, "+
+			"%d icons.", len(icons), buf.Len(), ix.Len())
+	}
+}
+
+// Hash parameters not fitting the index file format are not saved.
+func TestSaveIndexLimits(t *testing.T) {
+	for name, opts := range map[string]IndexOptions{
+		"buckets": {NumBuckets: 70000},
+		"points":  {Points: make([]Point, 70000)},
+	} {
+		var buf bytes.Buffer
+		if err := NewIndex(opts).Save(&buf); err == nil {
+			t.Errorf("%s: expected an error.", name)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("%s: expected nothing written, got %d bytes.",
+				name, buf.Len())
+		}
+	}
+	var buf bytes.Buffer
+	if err := NewIndex(IndexOptions{NumBuckets: 65535}).Save(&buf); err != nil {
+		t.Fatal("Error saving index:", err)
+	}
+	loaded, err := LoadIndex(&buf)
+	if err != nil {
+		t.Fatal("Error loading index:", err)
+	}
+	if loaded.opts.NumBuckets != 65535 {
+		t.Errorf("Expected 65535 buckets, got %d.", loaded.opts.NumBuckets)
+	}
+}
+
+func TestLoadIndexCorrupt(t *testing.T) {
+	ix, icons := testIndex(t, IndexOptions{})
+	var buf bytes.Buffer
+	if err := ix.Save(&buf); err != nil {
+		t.Fatal("Error saving index:", err)
+	}
+	snapshot := buf.Len()
+	if err := ix.AppendAdd(&buf, "new", icons[0]); err != nil {
+		t.Fatal("Error appending icon:", err)
+	}
+	data := buf.Bytes()
+
+	// Truncated snapshots and records, with all lengths near
+	// the snapshot end and the header.
+	for n := 0; n < len(data); n++ {
+		if n == snapshot || (n > 100 && n%97 != 0 &&
+			(n < snapshot-100 || n > snapshot+100)) {
+			continue
+		}
+		if _, err := LoadIndex(bytes.NewReader(data[:n])); !errors.Is(err, ErrIndexData) {
+			t.Fatalf("Expected ErrIndexData for %d of %d bytes, got %v.",
+				n, len(data), err)
+		}
+	}
+
+	// Changed bytes.
+	for _, i := range []int{0, 4, 10, snapshot / 2, snapshot - 1,
+		len(data) - 1} {
+		corrupt := append([]byte{}, data...)
+		corrupt[i] ^= 0x01
+		if _, err := LoadIndex(bytes.NewReader(corrupt)); !errors.Is(err, ErrIndexData) {
+			t.Errorf("Expected ErrIndexData for changed byte %d, got %v.",
+				i, err)
+		}
+	}
+}
@@ -0,0 +1,661 @@
+package images3
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+)
+
+// OpenForIcon opens and decodes an image file for icon generation
+// (see DecodeForIcon). size is icon size (0 means the default).
+func OpenForIcon(path string, size int) (img image.Image,
+	imgSize Point, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, Point{}, err
+	}
+	defer file.Close()
+	return DecodeForIcon(file, size)
+}
+
+// DecodeForIcon decodes an image for icon generation, possibly at
+// reduced resolution to save time and memory. Large baseline JPEG
+// images are decoded at 1/8 scale, when the reduced image is still
+// not smaller than the image resized within func Icon. Other images
+// are decoded at full resolution. size is icon size (0 means the
+// default). imgSize is the original image size, which should be set
+// as ImgSize of icons made from the returned image:
+//
+//	img, imgSize, err := images3.DecodeForIcon(r, 0)
+//	...
+//	icon := images3.Icon(img, path)
+//	icon.ImgSize = imgSize
+//
+// Each pixel of a reduced image is the average of an 8x8 pixel block.
+// Icons of reduced images differ from icons of fully decoded images
+// by EucMetric values below 1/10 of thresholds used by func Similar.
+func DecodeForIcon(r io.Reader, size int) (img image.Image,
+	imgSize Point, err error) {
+	return DecodeForIconWithOptions(r, size, OpenOptions{})
+}
+
+// Maximal length of image data per pixel, above the size of
+// uncompressed 16-bit RGBA pixels, and the allowance for metadata.
+// They limit reading of data for func DecodeForIconWithOptions.
+const (
+	maxBytesPerPixel = 8
+	maxMetadataLen   = 1 << 24
+)
+
+// DecodeForIconWithOptions decodes an image for icon generation
+// as func DecodeForIcon does, but with image opening parameters
+// opts (see func DecodeWithOptions). With opts.Orient, imgSize is
+// the size of the oriented image.
+func DecodeForIconWithOptions(r io.Reader, size int,
+	opts OpenOptions) (img image.Image, imgSize Point, err error) {
+	// Image size is checked before all data is read. Header data
+	// read by DecodeConfig is kept.
+	var head bytes.Buffer
+	config, _, err := image.DecodeConfig(io.TeeReader(r, &head))
+	if err != nil {
+		if err == image.ErrFormat {
+			err = ErrUnsupportedFormat
+		}
+		return nil, Point{}, err
+	}
+	if err = checkSize(config.Width, config.Height, opts); err != nil {
+		return nil, Point{}, err
+	}
+	maxLen := int64(config.Width)*int64(config.Height)*maxBytesPerPixel +
+		maxMetadataLen
+	data, err := io.ReadAll(io.LimitReader(
+		io.MultiReader(&head, r), maxLen+1))
+	if err != nil {
+		return nil, Point{}, err
+	}
+	if int64(len(data)) > maxLen {
+		return nil, Point{}, fmt.Errorf("%w: more than %d bytes of data",
+			ErrImageTooLarge, maxLen)
+	}
+
+	minSize := (optionSize(size)*2 + 1) * samples
+	img, imgSize, err = decodeJPEGReduced(data, minSize, opts)
+	if err == nil {
+		orientation := 1
+		if opts.Orient {
+			orientation = jpegOrientation(data)
+		}
+		return orient(img, orientation),
+			orientSize(imgSize, orientation), nil
+	}
+	if errors.Is(err, ErrImageTooLarge) {
+		return nil, Point{}, err
+	}
+	// Full resolution decoding of everything else.
+	img, _, err = DecodeWithOptions(bytes.NewReader(data), opts)
+	if err != nil {
+		return nil, Point{}, err
+	}
+	b := img.Bounds()
+	return img, Point{b.Dx(), b.Dy()}, nil
+}
+
+// JPEG markers.
+const (
+	mSOF0 = 0xc0 // Baseline DCT.
+	mSOF1 = 0xc1 // Extended sequential DCT, Huffman coding.
+	mDHT  = 0xc4
+	mRST0 = 0xd0
+	mRST7 = 0xd7
+	mSOI  = 0xd8
+	mEOI  = 0xd9
+	mSOS  = 0xda
+	mDQT  = 0xdb
+	mDRI  = 0xdd
+	mAPP0 = 0xe0
+	mAPP1 = 0xe1
+	mAPPE = 0xee // Adobe, with color transform info.
+)
+
+var (
+	errNotReducible = errors.New("images3: jpeg cannot be decoded at reduced scale")
+	errJPEGData     = errors.New("images3: invalid jpeg data")
+)
+
+// jpegComponent is a color component of a JPEG image.
+type jpegComponent struct {
+	id     byte
+	h, v   int    // Sampling factors.
+	tq     int    // Quantization table index.
+	td, ta int    // DC and AC Huffman table indices.
+	plane  []byte // Block averages, one byte per 8x8 block.
+	stride int    // Plane width.
+	pred   int    // DC predictor.
+}
+
+// dcDecoder decodes DC coefficients of baseline JPEG images,
+// giving images of 1/8 scale.
+type dcDecoder struct {
+	data        []byte
+	width       int // Full image size.
+	height      int
+	comps       []jpegComponent
+	hMax, vMax  int
+	mcuX, mcuY  int    // Number of MCUs of interleaved scans.
+	quant       [4]int // DC quantization values.
+	dc, ac      [4]*huffman
+	restart     int
+	adobe       bool
+	adobeRGB    bool
+	frameParsed bool
+	opts        OpenOptions // Image size limits.
+}
+
+// decodeJPEGReduced decodes a baseline JPEG image at 1/8 scale.
+// It returns errNotReducible for non-JPEG images, unsupported JPEG
+// variants, and images which would be reduced below minSize, and
+// ErrImageTooLarge for images above size limits of opts.
+func decodeJPEGReduced(data []byte, minSize int,
+	opts OpenOptions) (img image.Image, imgSize Point, err error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != mSOI {
+		return nil, Point{}, errNotReducible
+	}
+	d := &dcDecoder{data: data, opts: opts}
+	pos := 2
+	for {
+		marker, start, end, err := nextSegment(data, pos)
+		if err != nil {
+			return nil, Point{}, err
+		}
+		seg := data[start:end]
+		switch {
+		case marker == mEOI:
+			return d.image()
+		case marker == mSOF0 || marker == mSOF1:
+			if err = d.parseSOF(seg); err != nil {
+				return nil, Point{}, err
+			}
+			if (d.width+7)/8 < minSize || (d.height+7)/8 < minSize {
+				return nil, Point{}, errNotReducible
+			}
+		case marker >= 0xc2 && marker <= 0xcf &&
+			marker != mDHT && marker != 0xc8 && marker != 0xcc:
+			// Progressive, lossless and arithmetic coding.
+			return nil, Point{}, errNotReducible
+		case marker == mDHT:
+			err = d.parseDHT(seg)
+		case marker == mDQT:
+			err = d.parseDQT(seg)
+		case marker == mDRI:
+			if len(seg) != 2 {
+				return nil, Point{}, errJPEGData
+			}
+			d.restart = int(seg[0])<<8 | int(seg[1])
+		case marker == mAPPE:
+			if len(seg) >= 12 && string(seg[:5]) == "Adobe" {
+				d.adobe = true
+				d.adobeRGB = seg[11] == 0
+			}
+		case marker == mSOS:
+			if !d.frameParsed {
+				return nil, Point{}, errJPEGData
+			}
+			end, err = d.decodeScan(seg, end)
+		}
+		if err != nil {
+			return nil, Point{}, err
+		}
+		pos = end
+	}
+}
+
+// nextSegment finds the marker at pos and returns the marker with
+// bounds of its payload. Markers without payload have empty bounds.
+func nextSegment(data []byte, pos int) (marker byte, start, end int,
+	err error) {
+	if pos >= len(data) || data[pos] != 0xff {
+		return 0, 0, 0, errJPEGData
+	}
+	// Fill bytes.
+	for pos < len(data) && data[pos] == 0xff {
+		pos++
+	}
+	if pos >= len(data) {
+		return 0, 0, 0, errJPEGData
+	}
+	marker = data[pos]
+	pos++
+	if marker == mEOI || marker == mSOI ||
+		(marker >= mRST0 && marker <= mRST7) {
+		return marker, pos, pos, nil
+	}
+	if pos+2 > len(data) {
+		return 0, 0, 0, errJPEGData
+	}
+	n := int(data[pos])<<8 | int(data[pos+1])
+	if n < 2 || pos+n > len(data) {
+		return 0, 0, 0, errJPEGData
+	}
+	return marker, pos + 2, pos + n, nil
+}
+
+func (d *dcDecoder) parseSOF(seg []byte) error {
+	if d.frameParsed {
+		return errJPEGData
+	}
+	// 8-bit precision only.
+	if len(seg) < 6 || seg[0] != 8 {
+		return errNotReducible
+	}
+	d.height = int(seg[1])<<8 | int(seg[2])
+	d.width = int(seg[3])<<8 | int(seg[4])
+	n := int(seg[5])
+	if (n != 1 && n != 3) || len(seg) != 6+3*n ||
+		d.width == 0 || d.height == 0 {
+		return errNotReducible
+	}
+	if err := checkSize(d.width, d.height, d.opts); err != nil {
+		return err
+	}
+	d.comps = make([]jpegComponent, n)
+	d.hMax, d.vMax = 1, 1
+	for i := range d.comps {
+		c := &d.comps[i]
+		p := seg[6+3*i:]
+		c.id, c.h, c.v, c.tq = p[0], int(p[1]>>4), int(p[1]&15), int(p[2])
+		if c.h < 1 || c.h > 4 || c.v < 1 || c.v > 4 || c.tq > 3 {
+			return errJPEGData
+		}
+		if c.h > d.hMax {
+			d.hMax = c.h
+		}
+		if c.v > d.vMax {
+			d.vMax = c.v
+		}
+	}
+	if n == 1 {
+		// A single component is never interleaved, and its
+		// sampling factors do not matter.
+		d.comps[0].h, d.comps[0].v, d.hMax, d.vMax = 1, 1, 1, 1
+	}
+	d.mcuX = (d.width + 8*d.hMax - 1) / (8 * d.hMax)
+	d.mcuY = (d.height + 8*d.vMax - 1) / (8 * d.vMax)
+	for i := range d.comps {
+		c := &d.comps[i]
+		c.stride = d.mcuX * c.h
+		c.plane = make([]byte, c.stride*d.mcuY*c.v)
+	}
+	d.frameParsed = true
+	return nil
+}
+
+func (d *dcDecoder) parseDQT(seg []byte) error {
+	for len(seg) > 0 {
+		pq, tq := seg[0]>>4, int(seg[0]&15)
+		if tq > 3 {
+			return errJPEGData
+		}
+		switch {
+		case pq == 0 && len(seg) >= 65:
+			d.quant[tq] = int(seg[1])
+			seg = seg[65:]
+		case pq == 1 && len(seg) >= 129:
+			d.quant[tq] = int(seg[1])<<8 | int(seg[2])
+			seg = seg[129:]
+		default:
+			return errJPEGData
+		}
+	}
+	return nil
+}
+
+func (d *dcDecoder) parseDHT(seg []byte) error {
+	for len(seg) > 0 {
+		if len(seg) < 17 {
+			return errJPEGData
+		}
+		tc, th := seg[0]>>4, int(seg[0]&15)
+		if tc > 1 || th > 3 {
+			return errJPEGData
+		}
+		var counts [17]int
+		total := 0
+		for l := 1; l <= 16; l++ {
+			counts[l] = int(seg[l])
+			total += counts[l]
+		}
+		if total > 256 || len(seg) < 17+total {
+			return errJPEGData
+		}
+		h, err := newHuffman(counts, seg[17:17+total])
+		if err != nil {
+			return err
+		}
+		if tc == 0 {
+			d.dc[th] = h
+		} else {
+			d.ac[th] = h
+		}
+		seg = seg[17+total:]
+	}
+	return nil
+}
+
+// decodeScan decodes entropy-coded data following the SOS segment
+// header seg, which ends at pos. It returns the position of the
+// next marker after the scan.
+func (d *dcDecoder) decodeScan(seg []byte, pos int) (int, error) {
+	if len(seg) < 1 {
+		return 0, errJPEGData
+	}
+	ns := int(seg[0])
+	if ns < 1 || ns > len(d.comps) || len(seg) != 4+2*ns {
+		return 0, errJPEGData
+	}
+	scan := make([]*jpegComponent, ns)
+	for i := range scan {
+		id, t := seg[1+2*i], seg[2+2*i]
+		for j := range d.comps {
+			if d.comps[j].id == id {
+				scan[i] = &d.comps[j]
+			}
+		}
+		if scan[i] == nil {
+			return 0, errJPEGData
+		}
+		scan[i].td, scan[i].ta = int(t>>4), int(t&15)
+		if scan[i].td > 3 || scan[i].ta > 3 ||
+			d.dc[scan[i].td] == nil || d.ac[scan[i].ta] == nil {
+			return 0, errJPEGData
+		}
+		scan[i].pred = 0
+	}
+
+	// Number of MCUs. Non-interleaved scans have MCUs of one block.
+	mcuX, mcuY := d.mcuX, d.mcuY
+	if ns == 1 {
+		c := scan[0]
+		mcuX = ((d.width*c.h+d.hMax-1)/d.hMax + 7) / 8
+		mcuY = ((d.height*c.v+d.vMax-1)/d.vMax + 7) / 8
+	}
+
+	br := &bitReader{data: d.data, pos: pos}
+	mcus := 0
+	for my := 0; my < mcuY; my++ {
+		for mx := 0; mx < mcuX; mx++ {
+			if d.restart > 0 && mcus > 0 && mcus%d.restart == 0 {
+				if err := br.restart(); err != nil {
+					return 0, err
+				}
+				for _, c := range scan {
+					c.pred = 0
+				}
+			}
+			mcus++
+			for _, c := range scan {
+				if ns == 1 {
+					if err := d.decodeBlock(br, c,
+						my*c.stride+mx); err != nil {
+						return 0, err
+					}
+					continue
+				}
+				for v := 0; v < c.v; v++ {
+					for h := 0; h < c.h; h++ {
+						if err := d.decodeBlock(br, c,
+							(my*c.v+v)*c.stride+mx*c.h+h); err != nil {
+							return 0, err
+						}
+					}
+				}
+			}
+		}
+	}
+	return br.nextMarker(), nil
+}
+
+// decodeBlock decodes an 8x8 block, storing its average value
+// at index i of the component plane.
+func (d *dcDecoder) decodeBlock(br *bitReader, c *jpegComponent,
+	i int) error {
+	s, err := br.decode(d.dc[c.td])
+	if err != nil {
+		return err
+	}
+	if s > 11 {
+		return errJPEGData
+	}
+	c.pred += br.receiveExtend(uint(s))
+	// AC coefficients are decoded to be skipped.
+	ac := d.ac[c.ta]
+	for k := 1; k < 64; k++ {
+		rs, err := br.decode(ac)
+		if err != nil {
+			return err
+		}
+		r, s := int(rs>>4), uint(rs&15)
+		if s == 0 {
+			if r != 15 {
+				break // End of block.
+			}
+			k += 15
+			continue
+		}
+		k += r
+		br.receiveExtend(s)
+	}
+	// The DC term of the inverse DCT is 1/8 of the coefficient.
+	v := (c.pred*d.quant[c.tq]+4)>>3 + 128
+	if v < 0 {
+		v = 0
+	} else if v > 255 {
+		v = 255
+	}
+	c.plane[i] = byte(v)
+	return nil
+}
+
+// image assembles the reduced image from component planes.
+func (d *dcDecoder) image() (image.Image, Point, error) {
+	if !d.frameParsed {
+		return nil, Point{}, errJPEGData
+	}
+	w, h := (d.width+7)/8, (d.height+7)/8
+	imgSize := Point{d.width, d.height}
+	rect := image.Rect(0, 0, w, h)
+	if len(d.comps) == 1 {
+		c := d.comps[0]
+		img := image.NewGray(rect)
+		for y := 0; y < h; y++ {
+			copy(img.Pix[y*img.Stride:y*img.Stride+w],
+				c.plane[y*c.stride:])
+		}
+		return img, imgSize, nil
+	}
+	// Images with RGB components.
+	if (d.adobe && d.adobeRGB) || (!d.adobe && d.comps[0].id == 'R' &&
+		d.comps[1].id == 'G' && d.comps[2].id == 'B') {
+		return nil, Point{}, errNotReducible
+	}
+	y, cb, cr := d.comps[0], d.comps[1], d.comps[2]
+	if cb.h != cr.h || cb.v != cr.v ||
+		y.h%cb.h != 0 || y.v%cb.v != 0 {
+		return nil, Point{}, errNotReducible
+	}
+	var ratio image.YCbCrSubsampleRatio
+	switch [2]int{y.h / cb.h, y.v / cb.v} {
+	case [2]int{1, 1}:
+		ratio = image.YCbCrSubsampleRatio444
+	case [2]int{2, 1}:
+		ratio = image.YCbCrSubsampleRatio422
+	case [2]int{2, 2}:
+		ratio = image.YCbCrSubsampleRatio420
+	case [2]int{1, 2}:
+		ratio = image.YCbCrSubsampleRatio440
+	case [2]int{4, 1}:
+		ratio = image.YCbCrSubsampleRatio411
+	case [2]int{4, 2}:
+		ratio = image.YCbCrSubsampleRatio410
+	default:
+		return nil, Point{}, errNotReducible
+	}
+	img := image.NewYCbCr(rect, ratio)
+	for row := 0; row < h; row++ {
+		copy(img.Y[row*img.YStride:row*img.YStride+w],
+			y.plane[row*y.stride:])
+	}
+	cw, ch := img.CStride, len(img.Cb)/img.CStride
+	if cw > cb.stride || ch*cb.stride > len(cb.plane) {
+		return nil, Point{}, errNotReducible
+	}
+	for row := 0; row < ch; row++ {
+		copy(img.Cb[row*cw:(row+1)*cw], cb.plane[row*cb.stride:])
+		copy(img.Cr[row*cw:(row+1)*cw], cr.plane[row*cr.stride:])
+	}
+	return img, imgSize, nil
+}
+
+// huffman is a Huffman decoding table.
+type huffman struct {
+	// lut maps next 8 bits to value<<8 | code length
+	// for codes up to 8 bits long, and to 0 otherwise.
+	lut     [256]uint16
+	maxCode [17]int32 // -1 for lengths with no codes.
+	valPtr  [17]int32
+	minCode [17]int32
+	vals    []byte
+}
+
+func newHuffman(counts [17]int, vals []byte) (*huffman, error) {
+	h := &huffman{vals: vals}
+	var code, k int32
+	for l := 1; l <= 16; l++ {
+		h.valPtr[l] = k
+		h.minCode[l] = code
+		n := int32(counts[l])
+		code += n
+		k += n
+		h.maxCode[l] = -1
+		if n > 0 {
+			h.maxCode[l] = code - 1
+		}
+		if code > 1<<l {
+			return nil, errJPEGData
+		}
+		if l <= 8 {
+			for c := h.minCode[l]; c < code; c++ {
+				entry := uint16(vals[h.valPtr[l]+c-h.minCode[l]])<<8 |
+					uint16(l)
+				shift := uint(8 - l)
+				for j := c << shift; j < (c+1)<<shift; j++ {
+					h.lut[j] = entry
+				}
+			}
+		}
+		code <<= 1
+	}
+	return h, nil
+}
+
+// bitReader reads entropy-coded JPEG data.
+type bitReader struct {
+	data   []byte
+	pos    int
+	acc    uint32 // Bits to be read, aligned to the most significant bit.
+	n      uint   // Number of bits in acc.
+	marker bool   // A marker was reached, so zero bits are fed.
+}
+
+// fill loads at least 25 bits to the accumulator.
+func (br *bitReader) fill() {
+	for br.n <= 24 {
+		var b byte
+		if !br.marker && br.pos < len(br.data) {
+			b = br.data[br.pos]
+			if b == 0xff {
+				if br.pos+1 < len(br.data) && br.data[br.pos+1] == 0 {
+					br.pos += 2 // Stuffed zero byte.
+				} else {
+					br.marker = true
+					b = 0
+				}
+			} else {
+				br.pos++
+			}
+		}
+		br.acc |= uint32(b) << (24 - br.n)
+		br.n += 8
+	}
+}
+
+func (br *bitReader) decode(h *huffman) (byte, error) {
+	br.fill()
+	if e := h.lut[br.acc>>24]; e != 0 {
+		l := uint(e & 0xff)
+		br.acc <<= l
+		br.n -= l
+		return byte(e >> 8), nil
+	}
+	for l := uint(9); l <= 16; l++ {
+		code := int32(br.acc >> (32 - l))
+		if code <= h.maxCode[l] {
+			br.acc <<= l
+			br.n -= l
+			return h.vals[h.valPtr[l]+code-h.minCode[l]], nil
+		}
+	}
+	return 0, errJPEGData
+}
+
+// receiveExtend reads an s-bit signed value.
+func (br *bitReader) receiveExtend(s uint) int {
+	if s == 0 {
+		return 0
+	}
+	br.fill()
+	v := int(br.acc >> (32 - s))
+	br.acc <<= s
+	br.n -= s
+	if v < 1<<(s-1) {
+		v -= 1<<s - 1
+	}
+	return v
+}
+
+// restart skips to the data following the next RST marker.
+func (br *bitReader) restart() error {
+	br.acc, br.n, br.marker = 0, 0, false
+	for br.pos+1 < len(br.data) {
+		if br.data[br.pos] == 0xff {
+			m := br.data[br.pos+1]
+			if m >= mRST0 && m <= mRST7 {
+				br.pos += 2
+				return nil
+			}
+			if m != 0 && m != 0xff {
+				return errJPEGData
+			}
+		}
+		br.pos++
+	}
+	return errJPEGData
+}
+
+// nextMarker returns the position of the next marker other than
+// RST after the entropy-coded data.
+func (br *bitReader) nextMarker() int {
+	pos := br.pos
+	for pos+1 < len(br.data) {
+		if br.data[pos] == 0xff {
+			m := br.data[pos+1]
+			if m != 0 && m != 0xff && (m < mRST0 || m > mRST7) {
+				return pos
+			}
+		}
+		pos++
+	}
+	return len(br.data)
+}
@@ -0,0 +1,273 @@
+package images3
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+// largeJPEG encodes an upscaled test image as a JPEG large enough
+// for reduced decoding.
+func largeJPEG(t testing.TB, gray bool) []byte {
+	img, err := Open(path.Join("testdata", "euclidean", "large.jpg"))
+	if err != nil {
+		t.Fatal("Error opening image:", err)
+	}
+	large, _, _ := ResizeByArea(img, 3200, 2400)
+	var src image.Image = &large
+	if gray {
+		g := image.NewGray(large.Bounds())
+		draw.Draw(g, g.Bounds(), &large, image.Point{}, draw.Src)
+		src = g
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, src, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal("Error encoding image:", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeForIconReduced(t *testing.T) {
+	for _, gray := range []bool{false, true} {
+		data := largeJPEG(t, gray)
+		reduced, imgSize, err := DecodeForIcon(bytes.NewReader(data), 0)
+		if err != nil {
+			t.Fatal("Error decoding image:", err)
+		}
+		if imgSize != (Point{3200, 2400}) {
+			t.Errorf("Expected image size (3200, 2400), got %v.", imgSize)
+		}
+		if reduced.Bounds() != image.Rect(0, 0, 400, 300) {
+			t.Fatalf("Expected 400x300 reduced image, got %v.",
+				reduced.Bounds())
+		}
+		testReduced(t, "", data, reduced, imgSize, 0, gray)
+	}
+}
+
+// testReduced compares an image reduced from JPEG data for icons
+// of the given size with the fully decoded image.
+func testReduced(t *testing.T, name string, data []byte,
+	reduced image.Image, imgSize Point, size int, gray bool) {
+	full, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal("Error decoding image:", err)
+	}
+
+	// Reduced pixels are averages of 8x8 blocks.
+	fullAt, reducedAt := rgbaFunc(full), rgbaFunc(reduced)
+	var diff, n float64
+	for y := 0; y < full.Bounds().Dy()/8; y++ {
+		for x := 0; x < full.Bounds().Dx()/8; x++ {
+			var sum uint32
+			for m := 0; m < 8; m++ {
+				for k := 0; k < 8; k++ {
+					_, g, _, _ := fullAt(8*x+k, 8*y+m)
+					sum += g >> 8
+				}
+			}
+			_, g, _, _ := reducedAt(x, y)
+			d := float64(sum)/64 - float64(g>>8)
+			if d < 0 {
+				d = -d
+			}
+			diff += d
+			n++
+		}
+	}
+	if diff/n > 2 {
+		t.Errorf("%sMean difference %v from block averages is too large.",
+			name, diff/n)
+	}
+
+	// Icon tolerance.
+	opts := IconOptions{Size: size}
+	iconA := IconWithOptions(full, "", opts)
+	iconB := IconWithOptions(reduced, "", opts)
+	iconB.ImgSize = imgSize
+	if iconA.ImgSize != iconB.ImgSize {
+		t.Errorf("%sImage sizes mismatch %v, %v.",
+			name, iconA.ImgSize, iconB.ImgSize)
+	}
+	m1, m2, m3 := EucMetric(iconA, iconB)
+	if gray {
+		// Chroma of gray images is float rounding noise
+		// stretched by normalization.
+		m2, m3 = 0, 0
+	}
+	tY, tCbCr := eucThresholds(iconA.size())
+	if m1 > tY/10 || m2 > tCbCr/10 || m3 > tCbCr/10 {
+		t.Errorf("%sMetrics %v, %v, %v exceed the tolerance.",
+			name, m1, m2, m3)
+	}
+}
+
+// Fixtures have restart intervals, including ones not dividing the
+// number of MCUs, and chroma subsampling other than 4:2:0 of the
+// image/jpeg encoder. They are reducible for icons of size 5.
+func TestDecodeForIconRestart(t *testing.T) {
+	for _, name := range []string{"restart-420.jpg", "restart-422.jpg",
+		"restart-444.jpg", "restart-gray.jpg"} {
+		data, err := os.ReadFile(path.Join("testdata", "jpeg", name))
+		if err != nil {
+			t.Fatal("Error reading file:", err)
+		}
+		reduced, imgSize, err := DecodeForIcon(bytes.NewReader(data), 5)
+		if err != nil {
+			t.Fatalf("Error decoding %s: %v", name, err)
+		}
+		if imgSize != (Point{1058, 1050}) ||
+			reduced.Bounds() != image.Rect(0, 0, 133, 132) {
+			t.Fatalf("%s: expected image size (1058, 1050) and 133x132 "+
+				"reduced image, got %v, %v.", name, imgSize, reduced.Bounds())
+		}
+		testReduced(t, name+": ", data, reduced, imgSize, 5,
+			name == "restart-gray.jpg")
+		// Restart markers are missing in truncated data.
+		if _, _, err := decodeJPEGReduced(
+			data[:len(data)/2], 1, OpenOptions{}); err == nil {
+			t.Errorf("%s: expected an error for truncated data.", name)
+		}
+	}
+}
+
+func TestDecodeForIconFullScale(t *testing.T) {
+	for _, name := range []string{"large.jpg", "uniform-green.png"} {
+		filePath := path.Join("testdata", "euclidean", name)
+		img, imgSize, err := OpenForIcon(filePath, 0)
+		if err != nil {
+			t.Fatal("Error opening image:", err)
+		}
+		want, err := Open(filePath)
+		if err != nil {
+			t.Fatal("Error opening image:", err)
+		}
+		if !reflect.DeepEqual(img, want) {
+			t.Errorf("Expected full decoding of %s.", name)
+		}
+		b := want.Bounds()
+		if imgSize != (Point{b.Dx(), b.Dy()}) {
+			t.Errorf("Expected image size %v, got %v.", b.Size(), imgSize)
+		}
+	}
+	// Larger icons need more pixels.
+	data := largeJPEG(t, false)
+	img, _, err := DecodeForIcon(bytes.NewReader(data), 16)
+	if err != nil {
+		t.Fatal("Error decoding image:", err)
+	}
+	if img.Bounds().Dx() != 3200 {
+		t.Errorf("Expected full decoding, got %v.", img.Bounds())
+	}
+}
+
+func TestDecodeForIconCorrupt(t *testing.T) {
+	data := largeJPEG(t, false)
+	for _, n := range []int{0, 2, 100, 1000, len(data) / 2} {
+		if _, _, err := DecodeForIcon(
+			bytes.NewReader(data[:n]), 0); err == nil {
+			t.Errorf("Expected an error for data truncated to %d bytes.", n)
+		}
+	}
+}
+
+func TestDecodeForIconLimits(t *testing.T) {
+	data := largeJPEG(t, false)
+	for _, opts := range []OpenOptions{
+		{MaxPixels: 1000}, {MaxDimension: 3199}} {
+		_, _, err := DecodeForIconWithOptions(bytes.NewReader(data), 0, opts)
+		if !errors.Is(err, ErrImageTooLarge) {
+			t.Errorf("%+v: expected ErrImageTooLarge, got %v.", opts, err)
+		}
+		// Limits are also checked by the reduced decoder itself.
+		_, _, err = decodeJPEGReduced(data, 1, opts)
+		if !errors.Is(err, ErrImageTooLarge) {
+			t.Errorf("%+v: expected ErrImageTooLarge of reduced decoding, "+
+				"got %v.", opts, err)
+		}
+	}
+	_, imgSize, err := DecodeForIconWithOptions(bytes.NewReader(data), 0,
+		OpenOptions{MaxDimension: 3200, MaxPixels: 3200 * 2400})
+	if err != nil || imgSize != (Point{3200, 2400}) {
+		t.Errorf("Expected image size (3200, 2400), got %v, %v.",
+			imgSize, err)
+	}
+	// Full scale decoding.
+	filePath := path.Join("testdata", "euclidean", "large.jpg")
+	file, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal("Error opening file:", err)
+	}
+	defer file.Close()
+	_, _, err = DecodeForIconWithOptions(file, 0, OpenOptions{MaxPixels: 1000})
+	if !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("Expected ErrImageTooLarge of full decoding, got %v.", err)
+	}
+
+	// Data is not read beyond the limit derived from image size.
+	r := io.MultiReader(bytes.NewReader(pngHeader(10, 10)), zeros{})
+	if _, _, err := DecodeForIcon(r, 0); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("Expected ErrImageTooLarge for endless data, got %v.", err)
+	}
+}
+
+// zeros is an endless reader of zero bytes.
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestDecodeForIconOrient(t *testing.T) {
+	// EXIF segment of orientation 6 (rotation by 90 degrees
+	// clockwise) is inserted after SOI.
+	exif, err := os.ReadFile(path.Join("testdata", "orientation", "6.jpg"))
+	if err != nil {
+		t.Fatal("Error reading file:", err)
+	}
+	i := bytes.Index(exif, []byte{0xff, 0xe1})
+	if i < 0 {
+		t.Fatal("Expected an APP1 segment.")
+	}
+	exif = exif[i : i+2+(int(exif[i+2])<<8|int(exif[i+3]))]
+	large := largeJPEG(t, false)
+	data := append(append(append([]byte{}, large[:2]...), exif...),
+		large[2:]...)
+
+	img, imgSize, err := DecodeForIconWithOptions(bytes.NewReader(data), 0,
+		OpenOptions{Orient: true})
+	if err != nil {
+		t.Fatal("Error decoding image:", err)
+	}
+	if imgSize != (Point{2400, 3200}) ||
+		img.Bounds() != image.Rect(0, 0, 300, 400) {
+		t.Errorf("Expected image size (2400, 3200) and 300x400 bounds, "+
+			"got %v, %v.", imgSize, img.Bounds())
+	}
+}
+
+func BenchmarkDecodeForIcon(b *testing.B) {
+	data := largeJPEG(b, false)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		DecodeForIcon(bytes.NewReader(data), 0)
+	}
+}
+
+func BenchmarkDecodeFull(b *testing.B) {
+	data := largeJPEG(b, false)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		image.Decode(bytes.NewReader(data))
+	}
+}
@@ -0,0 +1,99 @@
+package images3
+
+import (
+	"math"
+	"strconv"
+)
+
+// ColorSpace is a color space of icon pixels.
+type ColorSpace int
+
+const (
+	// ColorYCbCr is the default color space of icons, with luma
+	// and 2 chroma channels (see func EucMetric).
+	ColorYCbCr ColorSpace = iota
+	// ColorLab is CIELAB with the D65 white point. Channels L*, a*
+	// and b* are stored as L*·2.55, a*+128 and b*+128, which keeps
+	// them in the range 0-255 of YCbCr icons. Only L* is normalized.
+	// Icons are compared with func DeltaEMetric.
+	ColorLab
+)
+
+// String returns the color space name.
+func (c ColorSpace) String() string {
+	switch c {
+	case ColorYCbCr:
+		return "YCbCr"
+	case ColorLab:
+		return "Lab"
+	}
+	return "ColorSpace(" + strconv.Itoa(int(c)) + ")"
+}
+
+// Mean Delta E threshold of similarity of Lab icons. Re-encoded
+// and resized copies of an image are below 1, while a warm color
+// cast shifting red and blue components by 10% gives about 10.
+const thDeltaE = 5
+
+// D65 reference white in XYZ.
+const (
+	whiteX = 0.95047
+	whiteY = 1.0
+	whiteZ = 1.08883
+)
+
+// lab transforms 8-bit sRGB components to CIELAB values scaled
+// as described for ColorLab.
+func lab(r, g, b float32) (l, a, bb float32) {
+	rl, gl, bl := linear(r), linear(g), linear(b)
+	x := (0.4124564*rl + 0.3575761*gl + 0.1804375*bl) / whiteX
+	y := (0.2126729*rl + 0.7151522*gl + 0.0721750*bl) / whiteY
+	z := (0.0193339*rl + 0.1191920*gl + 0.9503041*bl) / whiteZ
+	fx, fy, fz := labF(x), labF(y), labF(z)
+	l = float32(116*fy-16) * 2.55
+	a = float32(500*(fx-fy)) + 128
+	bb = float32(200*(fy-fz)) + 128
+	return l, a, bb
+}
+
+// linear converts an 8-bit sRGB component to linear light 0-1.
+func linear(c float32) float64 {
+	v := float64(c) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// labF is the nonlinear function of CIELAB.
+func labF(t float64) float64 {
+	const e = 216.0 / 24389 // (6/29)^3.
+	if t > e {
+		return math.Cbrt(t)
+	}
+	return t*24389/27/116 + 16.0/116
+}
+
+// DeltaEMetric returns the mean CIE76 color difference Delta E
+// between pixels of 2 Lab icons (see ColorLab). Delta E of about
+// 2.3 is a just noticeable difference. Icons of different sizes,
+// not in the Lab color space, or raw icons compared with normalized
+// ones give infinity.
+func DeltaEMetric(iconA, iconB IconT) float32 {
+	size := iconA.size()
+	if size != iconB.size() || iconA.Raw != iconB.Raw ||
+		iconA.ColorSpace != ColorLab || iconB.ColorSpace != ColorLab {
+		return float32(math.Inf(1))
+	}
+	numIconPixels := size * size
+	var sum float64
+	for i := 0; i < numIconPixels; i++ {
+		dL := float64(iconA.Pixels[i]-iconB.Pixels[i]) / 2.55
+		da := float64(iconA.Pixels[i+numIconPixels] -
+			iconB.Pixels[i+numIconPixels])
+		db := float64(iconA.Pixels[i+2*numIconPixels] -
+			iconB.Pixels[i+2*numIconPixels])
+		sum += math.Sqrt(dL*dL + da*da + db*db)
+	}
+	return float32(sum / float64(numIconPixels))
+}
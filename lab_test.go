@@ -0,0 +1,144 @@
+package images3
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"path"
+	"testing"
+)
+
+func TestLab(t *testing.T) {
+	for _, c := range []struct {
+		r, g, b  float32
+		l, a, bb float32
+	}{
+		{0, 0, 0, 0, 128, 128},
+		{255, 255, 255, 255, 128, 128},
+		{255, 0, 0, 53.24 * 2.55, 80.09 + 128, 67.20 + 128},
+		{0, 0, 255, 32.30 * 2.55, 79.19 + 128, -107.86 + 128},
+	} {
+		l, a, bb := lab(c.r, c.g, c.b)
+		if math.Abs(float64(l-c.l)) > 0.1 || math.Abs(float64(a-c.a)) > 0.1 ||
+			math.Abs(float64(bb-c.bb)) > 0.1 {
+			t.Errorf("Expected %v, %v, %v for %v, %v, %v, got %v, %v, %v.",
+				c.l, c.a, c.bb, c.r, c.g, c.b, l, a, bb)
+		}
+	}
+}
+
+// colorCast returns a copy of img with red and blue components
+// scaled by 1+k and 1-k.
+func colorCast(img image.Image, k float64) image.Image {
+	b := img.Bounds()
+	dst := image.NewRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			dst.Set(x, y, color.RGBA{
+				uint8(math.Min(float64(r>>8)*(1+k), 255)), uint8(g >> 8),
+				uint8(float64(bl>>8) * (1 - k)), 255})
+		}
+	}
+	return dst
+}
+
+func TestSimilarLab(t *testing.T) {
+	p := path.Join("testdata", "euclidean")
+	opts := IconOptions{ColorSpace: ColorLab}
+	icons := make(map[string]IconT)
+	for _, name := range []string{"large.jpg", "small.jpg",
+		"distorted.jpg", "flipped.jpg"} {
+		img, err := Open(path.Join(p, name))
+		if err != nil {
+			t.Fatal("Error opening image:", err)
+		}
+		icons[name] = IconWithOptions(img, "", opts)
+		if icons[name].ColorSpace != ColorLab {
+			t.Errorf("Expected color space Lab, got %v.",
+				icons[name].ColorSpace)
+		}
+	}
+	large := icons["large.jpg"]
+	for name, want := range map[string]bool{"small.jpg": true,
+		"distorted.jpg": true, "flipped.jpg": false} {
+		if got := eucSimilar(large, icons[name]); got != want {
+			t.Errorf("Expected similarity %v of large.jpg to %s, "+
+				"got %v (Delta E %v).", want, name, got,
+				DeltaEMetric(large, icons[name]))
+		}
+	}
+
+	// A color cast is ignored in YCbCr, but not in Lab.
+	img, err := Open(path.Join(p, "large.jpg"))
+	if err != nil {
+		t.Fatal("Error opening image:", err)
+	}
+	cast := colorCast(img, 0.1)
+	if !Similar(Icon(img, ""), Icon(cast, "")) {
+		t.Error("Expected similarity of YCbCr icons of a color cast.")
+	}
+	if Similar(large, IconWithOptions(cast, "", opts)) {
+		t.Error("Expected non-similarity of Lab icons of a color cast.")
+	}
+}
+
+func TestColorSpaceMismatch(t *testing.T) {
+	img, err := Open(path.Join("testdata", "euclidean", "large.jpg"))
+	if err != nil {
+		t.Fatal("Error opening image:", err)
+	}
+	iconA := Icon(img, "")
+	iconB := IconWithOptions(img, "", IconOptions{ColorSpace: ColorLab})
+	if Similar(iconA, iconB) || Similar(iconB, iconA) {
+		t.Error("Icons of different color spaces must not be similar.")
+	}
+	if m1, _, _ := EucMetric(iconA, iconB); !math.IsInf(float64(m1), 1) {
+		t.Errorf("Expected an infinite metric, got %v.", m1)
+	}
+	if m := DeltaEMetric(iconA, iconA); !math.IsInf(float64(m), 1) {
+		t.Errorf("Expected an infinite Delta E of YCbCr icons, got %v.", m)
+	}
+	if _, _, _, err := EucMetricE(iconA, iconB); !errors.Is(err, ErrIconMismatch) {
+		t.Errorf("Expected ErrIconMismatch, got %v.", err)
+	}
+}
+
+// The transformation of Lab icons is chosen by Delta E, also when
+// the Euclidean distances of func EucMetric prefer another one.
+func TestSimilarMirroredLab(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	labIcon := func() IconT {
+		icon := sizedIcon(iconSize)
+		icon.ColorSpace = ColorLab
+		icon.ImgSize = Point{100, 100}
+		for i := range icon.Pixels {
+			icon.Pixels[i] = r.Float32() * 255
+		}
+		return icon
+	}
+	found := 0
+	for n := 0; n < 100; n++ {
+		iconA, iconB := labIcon(), labIcon()
+		_, _, _, tEuc := EucMetricMirrored(iconA, iconB)
+		best, want := float32(-1), Identity
+		for _, tr := range mirrors {
+			d := DeltaEMetric(iconA, iconB.Transformed(tr))
+			if best < 0 || d < best {
+				best, want = d, tr
+			}
+		}
+		if want == tEuc {
+			continue
+		}
+		found++
+		if _, got := SimilarMirrored(iconA, iconB); got != want {
+			t.Errorf("Expected transformation %v, got %v.", want, got)
+		}
+	}
+	if found == 0 {
+		t.Error("Expected icons with differing closest transformations.")
+	}
+}
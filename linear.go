@@ -0,0 +1,128 @@
+package images3
+
+import (
+	"image"
+	"math"
+)
+
+// linearLUT converts 8-bit sRGB components to linear light
+// in the range 0-255.
+var linearLUT = func() (lut [256]float32) {
+	for i := range lut {
+		lut[i] = float32(linear(float32(i)) * 255)
+	}
+	return lut
+}()
+
+// linearPixel returns linear light components of a premultiplied
+// 8-bit RGBA pixel p, also premultiplied. Colors of translucent
+// pixels are linearized before premultiplication.
+func linearPixel(p []uint8) (r, g, b float32) {
+	switch p[3] {
+	case 255:
+		return linearLUT[p[0]], linearLUT[p[1]], linearLUT[p[2]]
+	case 0:
+		return 0, 0, 0
+	}
+	a := float32(p[3])
+	k := 255 / a
+	return float32(linear(float32(p[0])*k)) * a,
+		float32(linear(float32(p[1])*k)) * a,
+		float32(linear(float32(p[2])*k)) * a
+}
+
+// encodeSRGB converts a linear light component in the range 0-255
+// to an sRGB component in the same range.
+func encodeSRGB(c float32) float32 {
+	v := float64(c) / 255
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 1:
+		return 255
+	case v <= 0.0031308:
+		return float32(v * 12.92 * 255)
+	}
+	return float32((1.055*math.Pow(v, 1/2.4) - 0.055) * 255)
+}
+
+// encodeLinear converts icon pixels from linear RGB to the color
+// space cs.
+func (icon IconT) encodeLinear(size int, cs ColorSpace) {
+	var c1, c2, c3 float32
+	for x := 0; x < size; x++ {
+		for y := 0; y < size; y++ {
+			r, g, b := get(icon, size, Point{x, y})
+			r, g, b = encodeSRGB(r), encodeSRGB(g), encodeSRGB(b)
+			if cs == ColorLab {
+				c1, c2, c3 = lab(r, g, b)
+			} else {
+				c1, c2, c3 = yCbCr(r, g, b)
+			}
+			set(icon, size, Point{x, y}, c1, c2, c3)
+		}
+	}
+}
+
+// ResizeByAreaLinear resizes an image as func ResizeByArea does,
+// but averages colors in linear light, as gamma-correct image
+// resizing tools do. Output colors are sRGB encoded.
+func ResizeByAreaLinear(src image.Image, dstX, dstY int) (dst image.RGBA,
+	srcX, srcY int) {
+	// Original image size.
+	xMax, xMin := src.Bounds().Max.X, src.Bounds().Min.X
+	yMax, yMin := src.Bounds().Max.Y, src.Bounds().Min.Y
+	srcX = xMax - xMin
+	srcY = yMax - yMin
+
+	// Destination rectangle.
+	outRect := image.Rectangle{image.Point{0, 0}, image.Point{dstX, dstY}}
+	// Color model of uint8 per color.
+	dst = *image.NewRGBA(outRect)
+	if srcX <= 0 || srcY <= 0 {
+		return dst, srcX, srcY
+	}
+
+	at := rgbaFunc(src)
+	wx := areaWeights(srcX, dstX)
+	wy := areaWeights(srcY, dstY)
+	// Weights of an output pixel sum up to srcX*srcY.
+	total := float64(srcX) * float64(srcY)
+	var (
+		r, g, b, a             uint32
+		sumR, sumG, sumB, sumA float64
+		w                      float64
+		p                      [4]uint8
+	)
+	for y := 0; y < dstY; y++ {
+		for x := 0; x < dstX; x++ {
+			sumR, sumG, sumB, sumA = 0, 0, 0, 0
+			for _, cy := range wy[y] {
+				for _, cx := range wx[x] {
+					r, g, b, a = at(cx.src+xMin, cy.src+yMin)
+					p = [4]uint8{uint8(r >> 8), uint8(g >> 8),
+						uint8(b >> 8), uint8(a >> 8)}
+					lr, lg, lb := linearPixel(p[:])
+					w = float64(cx.w) * float64(cy.w)
+					sumR += float64(lr) * w
+					sumG += float64(lg) * w
+					sumB += float64(lb) * w
+					sumA += float64(p[3]) * w
+				}
+			}
+			i := dst.PixOffset(x, y)
+			alpha := sumA / total
+			dst.Pix[i+3] = uint8(alpha + 0.5)
+			if sumA == 0 {
+				continue
+			}
+			// Colors are encoded unpremultiplied.
+			k := float32(255 / sumA)
+			am := float32(alpha / 255)
+			dst.Pix[i+0] = uint8(encodeSRGB(float32(sumR)*k)*am + 0.5)
+			dst.Pix[i+1] = uint8(encodeSRGB(float32(sumG)*k)*am + 0.5)
+			dst.Pix[i+2] = uint8(encodeSRGB(float32(sumB)*k)*am + 0.5)
+		}
+	}
+	return dst, srcX, srcY
+}
@@ -0,0 +1,128 @@
+package images3
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math"
+	"path"
+	"testing"
+)
+
+func TestLinearLUT(t *testing.T) {
+	if linearLUT[0] != 0 || linearLUT[255] != 255 {
+		t.Errorf("Expected 0 and 255 at ends, got %v and %v.",
+			linearLUT[0], linearLUT[255])
+	}
+	for i, v := range linearLUT {
+		if c := encodeSRGB(v); math.Abs(float64(c)-float64(i)) > 0.01 {
+			t.Errorf("Expected %v after encoding, got %v.", i, c)
+		}
+	}
+	// Mid-gray of linear light.
+	if c := encodeSRGB(127.5); math.Abs(float64(c)-187.5) > 0.5 {
+		t.Errorf("Expected 187.5, got %v.", c)
+	}
+}
+
+// checkerboard returns an image of black and white pixels.
+func checkerboard(size int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if (x+y)%2 == 0 {
+				img.SetGray(x, y, color.Gray{255})
+			}
+		}
+	}
+	return img
+}
+
+func TestIconLinear(t *testing.T) {
+	// Fine detail averages to the gray of linear light in linear
+	// mode, but darkens in the default mode. Icons of uniform
+	// images are not normalized.
+	checker := checkerboard(resizedImgSize * 2)
+	for _, c := range []struct {
+		opts IconOptions
+		want float32
+	}{
+		{IconOptions{Resample: ResampleArea}, 127.5},
+		{IconOptions{Resample: ResampleArea, Linear: true}, 187.5},
+	} {
+		icon := IconWithOptions(checker, "", c.opts)
+		if y := icon.Pixels[0]; math.Abs(float64(y-c.want)) > 1 {
+			t.Errorf("Expected luma %v for %+v, got %v.", c.want, c.opts, y)
+		}
+	}
+
+	// Uniform colors do not change.
+	uniform := image.NewUniform(color.RGBA{200, 100, 50, 255})
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 100; x++ {
+			img.Set(x, y, uniform.C)
+		}
+	}
+	iconA := Icon(img, "")
+	for _, opts := range []IconOptions{{Linear: true},
+		{Linear: true, Resample: ResampleArea}} {
+		m1, m2, m3 := EucMetric(iconA, IconWithOptions(img, "", opts))
+		if m1 > 1 || m2 > 1 || m3 > 1 {
+			t.Errorf("Expected equal icons for %+v, got %v, %v, %v.",
+				opts, m1, m2, m3)
+		}
+	}
+}
+
+func TestResizeByAreaLinear(t *testing.T) {
+	dst, _, _ := ResizeByAreaLinear(checkerboard(200), 100, 100)
+	want := []uint8{188, 188, 188, 255}
+	for i := 0; i < len(dst.Pix); i += 4 {
+		if !bytes.Equal(dst.Pix[i:i+4], want) {
+			t.Fatalf("Expected opaque linear light gray %v, got %v.",
+				want, dst.Pix[i:i+4])
+		}
+	}
+}
+
+func TestEucMetricLinear(t *testing.T) {
+	p := path.Join("testdata", "euclidean")
+	large, err := Open(path.Join(p, "large.jpg"))
+	if err != nil {
+		t.Fatal("Error opening image:", err)
+	}
+	gamma := IconOptions{Resample: ResampleArea}
+	linear := IconOptions{Resample: ResampleArea, Linear: true}
+
+	// A copy resized by a gamma-correct tool gets closer icons
+	// in linear mode.
+	copyImg, _, _ := ResizeByAreaLinear(large, 267, 200)
+	m1Gamma, _, _ := EucMetric(IconWithOptions(large, "", gamma),
+		IconWithOptions(&copyImg, "", gamma))
+	m1Linear, _, _ := EucMetric(IconWithOptions(large, "", linear),
+		IconWithOptions(&copyImg, "", linear))
+	if m1Linear >= m1Gamma/10 {
+		t.Errorf("Expected a much smaller metric in linear mode, "+
+			"got %v vs %v.", m1Linear, m1Gamma)
+	}
+
+	// Verdicts for the euclidean testdata do not change.
+	for name, want := range map[string]bool{"small.jpg": true,
+		"distorted.jpg": true, "flipped.jpg": false} {
+		img, err := Open(path.Join(p, name))
+		if err != nil {
+			t.Fatal("Error opening image:", err)
+		}
+		for _, opts := range []IconOptions{{}, {Linear: true}, gamma, linear} {
+			iconA := IconWithOptions(large, "", opts)
+			iconB := IconWithOptions(img, "", opts)
+			m1, m2, m3 := EucMetric(iconA, iconB)
+			t.Logf("%s, %+v: %.0f, %.0f, %.0f", name, opts, m1, m2, m3)
+			if eucSimilar(iconA, iconB) != want {
+				t.Errorf("Expected similarity %v of large.jpg to %s for %+v.",
+					want, name, opts)
+			}
+		}
+	}
+}
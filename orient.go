@@ -0,0 +1,71 @@
+package images3
+
+import (
+	"image"
+	"image/color"
+)
+
+// orient returns an image transformed according to the EXIF
+// orientation tag value (1 to 8), i.e. as it is meant to be
+// displayed. The result shares pixels with the source image.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	return orientedImage{img, orientation}
+}
+
+// orientedImage is an image with EXIF orientation applied.
+type orientedImage struct {
+	src         image.Image
+	orientation int
+}
+
+// swapsAxes tells whether orientation transposes the image.
+func swapsAxes(orientation int) bool {
+	return orientation >= 5 && orientation <= 8
+}
+
+func (o orientedImage) ColorModel() color.Model {
+	return o.src.ColorModel()
+}
+
+func (o orientedImage) Bounds() image.Rectangle {
+	b := o.src.Bounds()
+	if swapsAxes(o.orientation) {
+		return image.Rect(0, 0, b.Dy(), b.Dx())
+	}
+	return image.Rect(0, 0, b.Dx(), b.Dy())
+}
+
+func (o orientedImage) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(o.Bounds())) {
+		return color.RGBA{}
+	}
+	return o.src.At(o.srcPoint(x, y))
+}
+
+// srcPoint maps a point of the oriented image to the source image.
+func (o orientedImage) srcPoint(x, y int) (sx, sy int) {
+	b := o.src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	switch o.orientation {
+	case 2: // Mirrored horizontally.
+		sx, sy = w-1-x, y
+	case 3: // Rotated by 180 degrees.
+		sx, sy = w-1-x, h-1-y
+	case 4: // Mirrored vertically.
+		sx, sy = x, h-1-y
+	case 5: // Transposed.
+		sx, sy = y, x
+	case 6: // To be rotated by 90 degrees clockwise.
+		sx, sy = y, h-1-x
+	case 7: // Transversed.
+		sx, sy = w-1-y, h-1-x
+	case 8: // To be rotated by 90 degrees counterclockwise.
+		sx, sy = w-1-y, x
+	default:
+		sx, sy = x, y
+	}
+	return sx + b.Min.X, sy + b.Min.Y
+}
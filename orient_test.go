@@ -0,0 +1,77 @@
+package images3
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+// Fixtures are the same image stored with all 8 EXIF orientations.
+func TestOpenWithOptionsOrient(t *testing.T) {
+	base, err := Open(path.Join("testdata", "euclidean", "large.jpg"))
+	if err != nil {
+		t.Fatal("Error opening image:", err)
+	}
+	baseIcon := Icon(base, "")
+	for o := 1; o <= 8; o++ {
+		filePath := path.Join("testdata", "orientation",
+			fmt.Sprintf("%d.jpg", o))
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			t.Fatal("Error reading file:", err)
+		}
+		if got := jpegOrientation(data); got != o {
+			t.Errorf("Expected orientation %d, got %d.", o, got)
+		}
+
+		img, err := OpenWithOptions(filePath, OpenOptions{Orient: true})
+		if err != nil {
+			t.Fatal("Error opening image:", err)
+		}
+		icon := Icon(img, "")
+		if icon.ImgSize != (Point{533, 400}) {
+			t.Errorf("Orientation %d: expected size (533, 400), got %v.",
+				o, icon.ImgSize)
+		}
+		if !Similar(icon, baseIcon) {
+			t.Errorf("Orientation %d: expected similarity.", o)
+		}
+		// The same icon with fast pixel access.
+		if !reflect.DeepEqual(icon, Icon(genericImage{img}, "")) {
+			t.Errorf("Orientation %d: icon mismatch.", o)
+		}
+
+		// Without the option.
+		img, err = OpenWithOptions(filePath, OpenOptions{})
+		if err != nil {
+			t.Fatal("Error opening image:", err)
+		}
+		if o != 1 && Similar(Icon(img, ""), baseIcon) {
+			t.Errorf("Orientation %d: expected non-similarity.", o)
+		}
+
+		// IconFromFile.
+		icon, err = IconFromFile(filePath,
+			FileOptions{Open: OpenOptions{Orient: true}})
+		if err != nil {
+			t.Fatal("Error making icon:", err)
+		}
+		if icon.ImgSize != (Point{533, 400}) || !Similar(icon, baseIcon) {
+			t.Errorf("Orientation %d: expected similarity of IconFromFile.", o)
+		}
+	}
+}
+
+func TestOrientSize(t *testing.T) {
+	for o := 0; o <= 9; o++ {
+		want := Point{3, 2}
+		if o >= 5 && o <= 8 {
+			want = Point{2, 3}
+		}
+		if got := orientSize(Point{3, 2}, o); got != want {
+			t.Errorf("Orientation %d: want %v, got %v.", o, want, got)
+		}
+	}
+}
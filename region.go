@@ -0,0 +1,105 @@
+package images3
+
+import (
+	"image"
+	"image/color"
+)
+
+// IconOfRegion generates an icon for a region of an image, which
+// is useful for comparison of image parts. The region rect is
+// clipped to image bounds. ImgSize of the icon is the region size.
+func IconOfRegion(img image.Image, rect image.Rectangle,
+	path string) IconT {
+	return Icon(subImage(img, rect), path)
+}
+
+// GridT is an image signature consisting of icons of image regions
+// placed in a grid. Compared with a single icon it allows to find
+// images differing in a part only, e.g. with a changed caption.
+type GridT struct {
+	Rows, Cols int
+	Icons      []IconT // Region icons, row by row.
+	ImgSize    Point   // Original image size.
+}
+
+// GridIcons splits an image into rows x cols regions of equal size
+// and generates an icon for each of them. Rows and cols are limited
+// to image height and width, so that regions are never empty, and
+// the grid has fewer cells for images smaller than the grid.
+func GridIcons(img image.Image, rows, cols int) GridT {
+	b := img.Bounds()
+	if rows > b.Dy() {
+		rows = b.Dy()
+	}
+	if cols > b.Dx() {
+		cols = b.Dx()
+	}
+	if rows < 1 {
+		rows = 1
+	}
+	if cols < 1 {
+		cols = 1
+	}
+	grid := GridT{
+		Rows:    rows,
+		Cols:    cols,
+		Icons:   make([]IconT, 0, rows*cols),
+		ImgSize: Point{b.Dx(), b.Dy()}}
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols; c++ {
+			rect := image.Rect(
+				b.Min.X+c*b.Dx()/cols, b.Min.Y+r*b.Dy()/rows,
+				b.Min.X+(c+1)*b.Dx()/cols, b.Min.Y+(r+1)*b.Dy()/rows)
+			grid.Icons = append(grid.Icons, IconOfRegion(img, rect, ""))
+		}
+	}
+	return grid
+}
+
+// GridMatches compares region icons of 2 grids with func Similar.
+// It returns the number of similar regions and similarity verdicts
+// for each region, row by row. Grids of distinct shape have no
+// matching regions.
+func GridMatches(gridA, gridB GridT) (count int, cells []bool) {
+	if gridA.Rows != gridB.Rows || gridA.Cols != gridB.Cols ||
+		len(gridA.Icons) != len(gridB.Icons) {
+		return 0, nil
+	}
+	cells = make([]bool, len(gridA.Icons))
+	for i := range gridA.Icons {
+		if Similar(gridA.Icons[i], gridB.Icons[i]) {
+			cells[i] = true
+			count++
+		}
+	}
+	return count, cells
+}
+
+// subImage returns the part of an image inside rect, sharing
+// pixels with the image where possible.
+func subImage(img image.Image, rect image.Rectangle) image.Image {
+	rect = rect.Intersect(img.Bounds())
+	if s, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}); ok {
+		return s.SubImage(rect)
+	}
+	return regionImage{img, rect}
+}
+
+// regionImage restricts bounds of an image with no SubImage method.
+type regionImage struct {
+	image.Image
+	rect image.Rectangle
+}
+
+func (r regionImage) Bounds() image.Rectangle {
+	return r.rect
+}
+
+func (r regionImage) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(r.rect)) {
+		return color.RGBA{}
+	}
+	return r.Image.At(x, y)
+}
@@ -0,0 +1,90 @@
+package images3
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestIconOfRegion(t *testing.T) {
+	filePath := path.Join("testdata", "euclidean", "large.jpg")
+	img, err := Open(filePath)
+	if err != nil {
+		t.Fatal("Cannot decode", filePath)
+	}
+	// Whole image.
+	if !reflect.DeepEqual(Icon(img, filePath),
+		IconOfRegion(img, img.Bounds(), filePath)) {
+		t.Error("Icon of the full region must equal the image icon.")
+	}
+	// Region is clipped to image bounds.
+	icon := IconOfRegion(img, image.Rect(400, 300, 600, 600), "")
+	if icon.ImgSize != (Point{133, 100}) {
+		t.Errorf("Expected region size (133, 100), got %v.", icon.ImgSize)
+	}
+	// Images with no SubImage method.
+	rect := image.Rect(10, 20, 300, 250)
+	if !reflect.DeepEqual(IconOfRegion(img, rect, ""),
+		IconOfRegion(genericImage{img}, rect, "")) {
+		t.Error("Region icons mismatch for a generic image.")
+	}
+}
+
+func TestGridMatches(t *testing.T) {
+	filePath := path.Join("testdata", "euclidean", "large.jpg")
+	img, err := Open(filePath)
+	if err != nil {
+		t.Fatal("Cannot decode", filePath)
+	}
+	b := img.Bounds()
+	changed := image.NewRGBA(b)
+	draw.Draw(changed, b, img, b.Min, draw.Src)
+	// A "caption" in the lower right quadrant.
+	for y := 300; y < 380; y += 16 {
+		draw.Draw(changed, image.Rect(300, y, 520, y+8),
+			image.NewUniform(color.White), image.Point{}, draw.Src)
+	}
+
+	gridA := GridIcons(img, 2, 2)
+	gridB := GridIcons(changed, 2, 2)
+	if len(gridA.Icons) != 4 || gridA.ImgSize != (Point{533, 400}) {
+		t.Fatalf("Unexpected grid %d icons, size %v.",
+			len(gridA.Icons), gridA.ImgSize)
+	}
+	count, cells := GridMatches(gridA, gridB)
+	if count != 3 || !reflect.DeepEqual(
+		cells, []bool{true, true, true, false}) {
+		t.Errorf("Expected 3 matches except the last cell, got %d %v.",
+			count, cells)
+	}
+	count, _ = GridMatches(gridA, gridA)
+	if count != 4 {
+		t.Errorf("Expected 4 matches, got %d.", count)
+	}
+	// Distinct grid shapes.
+	count, cells = GridMatches(gridA, GridIcons(img, 3, 3))
+	if count != 0 || cells != nil {
+		t.Errorf("Expected no matches, got %d %v.", count, cells)
+	}
+
+	// Grids larger than images have no empty cells.
+	tiny := image.NewGray(image.Rect(0, 0, 3, 2))
+	tiny.Pix = []uint8{0, 100, 200, 50, 150, 250}
+	grid := GridIcons(tiny, 5, 5)
+	if grid.Rows != 2 || grid.Cols != 3 || len(grid.Icons) != 6 {
+		t.Fatalf("Expected a 2x3 grid, got %dx%d with %d icons.",
+			grid.Rows, grid.Cols, len(grid.Icons))
+	}
+	for i, icon := range grid.Icons {
+		if icon.ImgSize != (Point{1, 1}) {
+			t.Errorf("Expected cell %d of size (1, 1), got %v.",
+				i, icon.ImgSize)
+		}
+	}
+	if count, _ = GridMatches(grid, grid); count != 6 {
+		t.Errorf("Expected 6 matches, got %d.", count)
+	}
+}
@@ -1,5 +1,10 @@
 package images3
 
+import (
+	"fmt"
+	"math"
+)
+
 const (
 
 	// Euclidean similarity parameters.
@@ -10,13 +15,19 @@ const (
 	euclCoeff = 0.2
 	// Coefficient of sensitivity for Cb/Cr channels vs Y.
 	chanCoeff = 2
-	// Euclidean distance threshold (squared) for Y-channel.
+	// Euclidean distance threshold (squared) for Y-channel
+	// of default-size icons.
 	thY = float32(iconSize*iconSize) * float32(colorDiff*colorDiff) * euclCoeff
-	// Euclidean distance threshold (squared) for Cb and Cr channels.
+	// Euclidean distance threshold (squared) for Cb and Cr channels
+	// of default-size icons.
 	thCbCr = thY * chanCoeff
 
 	// Proportion similarity threshold 5%.
 	thProp = 0.05
+
+	// Brightness and contrast difference thresholds in 8-bit units.
+	thBrightness = 16
+	thContrast   = 24
 )
 
 // Similar returns similarity verdict based on Euclidean
@@ -42,11 +53,25 @@ func propSimilar(iconA, iconB IconT) bool {
 // and B. The smaller the metric the more similar are images by their
 // x-y size.
 func PropMetric(iconA, iconB IconT) (m float64) {
+	return propMetric(iconA.ImgSize, iconB.ImgSize)
+}
+
+// PropMetricE returns PropMetric of valid icons (see func Validate),
+// or an error instead of NaN or infinite metrics of degenerate ones.
+func PropMetricE(iconA, iconB IconT) (m float64, err error) {
+	if err = validatePair(iconA, iconB); err != nil {
+		return 0, err
+	}
+	return PropMetric(iconA, iconB), nil
+}
+
+// propMetric is PropMetric for image sizes sizeA and sizeB.
+func propMetric(sizeA, sizeB Point) (m float64) {
 
 	// Filtering is based on rescaling a narrower side of images to 1,
 	// then cutting off at threshold of a longer image vs shorter image.
-	xA, yA := float64(iconA.ImgSize.X), float64(iconA.ImgSize.Y)
-	xB, yB := float64(iconB.ImgSize.X), float64(iconB.ImgSize.Y)
+	xA, yA := float64(sizeA.X), float64(sizeA.Y)
+	xB, yB := float64(sizeB.X), float64(sizeB.Y)
 
 	if xA <= yA { // x to 1.
 		yA = yA / xA
@@ -75,8 +100,103 @@ func PropMetric(iconA, iconB IconT) (m float64) {
 // eucSimilar wraps EucMetric with well-tested thresholds.
 func eucSimilar(iconA, iconB IconT) bool {
 
+	if iconA.ColorSpace == ColorLab {
+		return DeltaEMetric(iconA, iconB) < thDeltaE
+	}
 	m1, m2, m3 := EucMetric(iconA, iconB)
-	return m1 < thY && m2 < thCbCr && m3 < thCbCr
+	tY, tCbCr := eucThresholds(iconA.size())
+	return m1 < tY && m2 < tCbCr && m3 < tCbCr
+}
+
+// eucThresholds returns thresholds thY and thCbCr scaled to
+// icon size, so that the cutoff per icon pixel stays the same.
+func eucThresholds(size int) (tY, tCbCr float32) {
+	tY = float32(size*size) * float32(colorDiff*colorDiff) * euclCoeff
+	return tY, tY * chanCoeff
+}
+
+// EucMetricE returns EucMetric of valid icons (see func Validate).
+// Mismatched icons, for which EucMetric is infinite, give
+// ErrIconMismatch.
+func EucMetricE(iconA, iconB IconT) (m1, m2, m3 float32, err error) {
+	if err = validatePair(iconA, iconB); err != nil {
+		return 0, 0, 0, err
+	}
+	if iconA.size() != iconB.size() {
+		return 0, 0, 0, fmt.Errorf("%w: sizes %d and %d",
+			ErrIconMismatch, iconA.size(), iconB.size())
+	}
+	if iconA.ColorSpace != iconB.ColorSpace {
+		return 0, 0, 0, fmt.Errorf("%w: color spaces %v and %v",
+			ErrIconMismatch, iconA.ColorSpace, iconB.ColorSpace)
+	}
+	if iconA.Raw != iconB.Raw {
+		return 0, 0, 0, fmt.Errorf("%w: raw and normalized icons",
+			ErrIconMismatch)
+	}
+	m1, m2, m3 = EucMetric(iconA, iconB)
+	return m1, m2, m3, nil
+}
+
+// validatePair validates icons to be compared.
+func validatePair(iconA, iconB IconT) error {
+	if err := iconA.Validate(); err != nil {
+		return fmt.Errorf("icon A: %w", err)
+	}
+	if err := iconB.Validate(); err != nil {
+		return fmt.Errorf("icon B: %w", err)
+	}
+	return nil
+}
+
+// EucWithin tells whether Euclidean distances (see func EucMetric)
+// between 2 icons are below thresholds thY for channel 1 and thCbCr
+// for channels 2 and 3. It gives the same result as comparison of
+// EucMetric values, but stops summation as soon as a threshold is
+// reached, so that it is much faster for distinct icons.
+func EucWithin(iconA, iconB IconT, thY, thCbCr float32) bool {
+	size := iconA.size()
+	if size != iconB.size() || iconA.ColorSpace != iconB.ColorSpace ||
+		iconA.Raw != iconB.Raw {
+		return false
+	}
+	numIconPixels := size * size
+	for ch, th := range [3]float32{thY, thCbCr, thCbCr} {
+		a := iconA.Pixels[ch*numIconPixels : (ch+1)*numIconPixels]
+		b := iconB.Pixels[ch*numIconPixels : (ch+1)*numIconPixels]
+		var m float32
+		// Thresholds are checked once per icon row.
+		for row := 0; row < numIconPixels; row += size {
+			for i := row; i < row+size; i++ {
+				m += (a[i] - b[i]) * (a[i] - b[i])
+			}
+			if m >= th {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// FindSimilar returns indices of icons similar to the query icon,
+// as func Similar would find them, but faster (see func EucWithin).
+func FindSimilar(query IconT, icons []IconT) (found []int) {
+	tY, tCbCr := eucThresholds(query.size())
+	for i := range icons {
+		if !propSimilar(query, icons[i]) {
+			continue
+		}
+		if query.ColorSpace == ColorLab {
+			if eucSimilar(query, icons[i]) {
+				found = append(found, i)
+			}
+			continue
+		}
+		if EucWithin(query, icons[i], tY, tCbCr) {
+			found = append(found, i)
+		}
+	}
+	return found
 }
 
 // EucMetric returns Euclidean distances between 2 icons.
@@ -84,9 +204,17 @@ func eucSimilar(iconA, iconB IconT) bool {
 // The distances are squared to avoid square root calculations.
 // Note that the channels are not RGB, but YCbCr, thus their
 // importance for similarity might be not the same.
+// Icons of different sizes or color spaces, and raw icons compared
+// with normalized ones give infinite distances.
 func EucMetric(iconA, iconB IconT) (m1, m2, m3 float32) {
 
-	numIconPixels := iconSize * iconSize
+	size := iconA.size()
+	if size != iconB.size() || iconA.ColorSpace != iconB.ColorSpace ||
+		iconA.Raw != iconB.Raw {
+		inf := float32(math.Inf(1))
+		return inf, inf, inf
+	}
+	numIconPixels := size * size
 	var cA, cB float32
 	for i := 0; i < numIconPixels; i++ {
 		// Channel 1.
@@ -105,3 +233,27 @@ func EucMetric(iconA, iconB IconT) (m1, m2, m3 float32) {
 
 	return m1, m2, m3
 }
+
+// ExposureMetric returns differences of brightness and contrast of
+// images A and B, which are lost in icon normalization. Brightness
+// is the middle of the luma range of an icon (see IconT.Min and
+// IconT.Max), and contrast is the width of the range. Both are
+// in 8-bit units.
+func ExposureMetric(iconA, iconB IconT) (brightness, contrast float32) {
+	brightness = (iconA.Min[0]+iconA.Max[0])/2 - (iconB.Min[0]+iconB.Max[0])/2
+	contrast = (iconA.Max[0] - iconA.Min[0]) - (iconB.Max[0] - iconB.Min[0])
+	return float32(math.Abs(float64(brightness))),
+		float32(math.Abs(float64(contrast)))
+}
+
+// SimilarExposure gives the verdict of func Similar, but also
+// requires similar brightness and contrast of images (see func
+// ExposureMetric). So it separates differently exposed copies
+// of a photo, e.g. for exposure bracketing.
+func SimilarExposure(iconA, iconB IconT) bool {
+	if !Similar(iconA, iconB) {
+		return false
+	}
+	brightness, contrast := ExposureMetric(iconA, iconB)
+	return brightness < thBrightness && contrast < thContrast
+}
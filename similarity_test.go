@@ -1,7 +1,13 @@
 package images3
 
 import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
 	"path"
+	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -80,3 +86,236 @@ func TestEucSimilar(t *testing.T) {
 	testEucSimilar("uniform-green.png", "uniform-white.png", false, t)
 	testEucSimilar("uniform-white.png", "uniform-white.png", true, t)
 }
+
+func testEucSimilarSized(fA, fB string, size int, isSimilar bool,
+	t *testing.T) {
+	p := path.Join("testdata", "euclidean")
+	imgA, err := Open(path.Join(p, fA))
+	if err != nil {
+		t.Error("Error opening image:", err)
+	}
+	imgB, err := Open(path.Join(p, fB))
+	if err != nil {
+		t.Error("Error opening image:", err)
+	}
+	iconA := IconWithOptions(imgA, "", IconOptions{Size: size})
+	iconB := IconWithOptions(imgB, "", IconOptions{Size: size})
+	if eucSimilar(iconA, iconB) != isSimilar {
+		t.Errorf("Expecting similarity %v of %v to %v for size %d.",
+			isSimilar, fA, fB, size)
+	}
+}
+
+func TestEucSimilarSized(t *testing.T) {
+	for _, size := range []int{16, 24} {
+		testEucSimilarSized("large.jpg", "small.jpg", size, true, t)
+		testEucSimilarSized("large.jpg", "distorted.jpg", size, true, t)
+		testEucSimilarSized("large.jpg", "flipped.jpg", size, false, t)
+		testEucSimilarSized("uniform-green.png", "uniform-white.png",
+			size, false, t)
+	}
+}
+
+func TestEucMetricSizeMismatch(t *testing.T) {
+	img, err := Open(path.Join("testdata", "euclidean", "large.jpg"))
+	if err != nil {
+		t.Error("Error opening image:", err)
+	}
+	iconA := Icon(img, "")
+	iconB := IconWithOptions(img, "", IconOptions{Size: 16})
+	m1, m2, m3 := EucMetric(iconA, iconB)
+	if !math.IsInf(float64(m1), 1) || !math.IsInf(float64(m2), 1) ||
+		!math.IsInf(float64(m3), 1) {
+		t.Errorf("Expected infinite metrics, got %v, %v, %v.", m1, m2, m3)
+	}
+	if Similar(iconA, iconB) {
+		t.Error("Icons of different sizes must not be similar.")
+	}
+}
+
+func TestMetricsE(t *testing.T) {
+	img, err := Open(path.Join("testdata", "euclidean", "large.jpg"))
+	if err != nil {
+		t.Error("Error opening image:", err)
+	}
+	iconA := Icon(img, "")
+	iconB := Icon(img, "")
+	if m, err := PropMetricE(iconA, iconB); err != nil || m != 0 {
+		t.Errorf("Expected 0 and no error, got %v, %v.", m, err)
+	}
+	m1, m2, m3, err := EucMetricE(iconA, iconB)
+	if err != nil || m1 != 0 || m2 != 0 || m3 != 0 {
+		t.Errorf("Expected zero metrics and no error, got %v, %v, %v, %v.",
+			m1, m2, m3, err)
+	}
+
+	// Empty and degenerate icons.
+	degenerate := iconB
+	degenerate.ImgSize = Point{0, 10}
+	for _, icon := range []IconT{EmptyIcon(), degenerate} {
+		if _, err := PropMetricE(iconA, icon); !errors.Is(err, ErrInvalidIcon) {
+			t.Errorf("Expected ErrInvalidIcon, got %v.", err)
+		}
+		if _, _, _, err := EucMetricE(icon, iconA); !errors.Is(err, ErrInvalidIcon) {
+			t.Errorf("Expected ErrInvalidIcon, got %v.", err)
+		}
+	}
+
+	// Icons of different sizes.
+	sized := IconWithOptions(img, "", IconOptions{Size: 16})
+	if _, _, _, err := EucMetricE(iconA, sized); !errors.Is(err, ErrIconMismatch) {
+		t.Errorf("Expected ErrIconMismatch, got %v.", err)
+	}
+}
+
+// exposed returns a copy of img with color components c changed
+// to c*gain+offset.
+func exposed(img image.Image, gain, offset float64) image.Image {
+	b := img.Bounds()
+	dst := image.NewRGBA(b)
+	level := func(c uint32) uint8 {
+		return uint8(math.Max(0, math.Min(255, float64(c>>8)*gain+offset)))
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			dst.Set(x, y, color.RGBA{level(r), level(g), level(bl), 255})
+		}
+	}
+	return dst
+}
+
+func TestSimilarExposure(t *testing.T) {
+	p := path.Join("testdata", "euclidean")
+	img, err := Open(path.Join(p, "large.jpg"))
+	if err != nil {
+		t.Fatal("Error opening image:", err)
+	}
+	icon := Icon(img, "")
+	small, err := Open(path.Join(p, "small.jpg"))
+	if err != nil {
+		t.Fatal("Error opening image:", err)
+	}
+	if !SimilarExposure(icon, Icon(small, "")) {
+		t.Error("Expected similar exposure of large.jpg and small.jpg.")
+	}
+	for _, c := range []struct {
+		gain, offset float64
+		want         bool
+	}{
+		{1, 10, true},
+		{1.1, 0, true},
+		{1, 30, false},
+		{1, -30, false},
+		{0.5, 0, false},
+		{1.2, -20, false},
+	} {
+		iconB := Icon(exposed(img, c.gain, c.offset), "")
+		// Normalized icons do not differ.
+		if !Similar(icon, iconB) {
+			t.Errorf("Expected similarity for gain %v and offset %v.",
+				c.gain, c.offset)
+		}
+		if got := SimilarExposure(icon, iconB); got != c.want {
+			brightness, contrast := ExposureMetric(icon, iconB)
+			t.Errorf("Expected exposure similarity %v for gain %v "+
+				"and offset %v, got %v (%v, %v).", c.want, c.gain,
+				c.offset, got, brightness, contrast)
+		}
+	}
+}
+
+// testdataIcons returns icons of testdata images and of their
+// mirrored copies.
+func testdataIcons(t testing.TB) (icons []IconT) {
+	files, err := filepath.Glob(path.Join("testdata", "*", "*.[jp][pn]g"))
+	if err != nil {
+		t.Fatal("Error listing files:", err)
+	}
+	for _, file := range files {
+		img, err := Open(file)
+		if err != nil {
+			t.Fatal("Error opening image:", err)
+		}
+		icon := Icon(img, file)
+		icons = append(icons, icon, icon.FlipH(), icon.FlipV())
+	}
+	return icons
+}
+
+func TestEucWithin(t *testing.T) {
+	icons := testdataIcons(t)
+	for _, a := range icons {
+		for _, b := range icons {
+			m1, m2, m3 := EucMetric(a, b)
+			want := m1 < thY && m2 < thCbCr && m3 < thCbCr
+			if got := EucWithin(a, b, thY, thCbCr); got != want {
+				t.Fatalf("Expected %v for %s and %s, got %v.",
+					want, a.Path, b.Path, got)
+			}
+			// Thresholds equal to the metrics.
+			if m1 > 0 && EucWithin(a, b, m1, float32(math.Inf(1))) {
+				t.Fatalf("Expected false for threshold %v.", m1)
+			}
+		}
+	}
+	sized := sizedIcon(16)
+	sized.Size = 16
+	if EucWithin(icons[0], sized, thY, thCbCr) {
+		t.Error("Icons of different sizes must not be within thresholds.")
+	}
+}
+
+func TestFindSimilar(t *testing.T) {
+	icons := testdataIcons(t)
+	for _, query := range icons {
+		var want []int
+		for i := range icons {
+			if Similar(query, icons[i]) {
+				want = append(want, i)
+			}
+		}
+		if got := FindSimilar(query, icons); !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %v for %s, got %v.", want, query.Path, got)
+		}
+	}
+}
+
+func BenchmarkEucWithin(b *testing.B) {
+	icons := testdataIcons(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, icon := range icons {
+			EucWithin(icons[0], icon, thY, thCbCr)
+		}
+	}
+}
+
+func BenchmarkEucMetric(b *testing.B) {
+	icons := testdataIcons(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, icon := range icons {
+			m1, m2, m3 := EucMetric(icons[0], icon)
+			_ = m1 < thY && m2 < thCbCr && m3 < thCbCr
+		}
+	}
+}
+
+func BenchmarkFindSimilar(b *testing.B) {
+	icons := testdataIcons(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		FindSimilar(icons[0], icons)
+	}
+}
+
+func BenchmarkSimilar(b *testing.B) {
+	icons := testdataIcons(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, icon := range icons {
+			Similar(icons[0], icon)
+		}
+	}
+}
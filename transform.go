@@ -0,0 +1,193 @@
+package images3
+
+// Transform is a geometric transformation of an icon.
+type Transform int
+
+const (
+	// Identity leaves an icon as is.
+	Identity Transform = iota
+	// FlipHorizontal mirrors an icon left to right.
+	FlipHorizontal
+	// FlipVertical mirrors an icon top to bottom.
+	FlipVertical
+	// Rotate90 rotates an icon by 90 degrees clockwise.
+	Rotate90
+	// Rotate180 rotates an icon by 180 degrees.
+	Rotate180
+	// Rotate270 rotates an icon by 270 degrees clockwise.
+	Rotate270
+)
+
+// FlipH returns a copy of the icon mirrored left to right, which
+// is the icon of the mirrored image. Other icon fields are copied.
+func (icon IconT) FlipH() IconT {
+	return icon.remap(func(x, y, size int) Point {
+		return Point{size - 1 - x, y}
+	})
+}
+
+// FlipV returns a copy of the icon mirrored top to bottom, which
+// is the icon of the mirrored image. Other icon fields are copied.
+func (icon IconT) FlipV() IconT {
+	return icon.remap(func(x, y, size int) Point {
+		return Point{x, size - 1 - y}
+	})
+}
+
+// Rot90 returns a copy of the icon rotated by 90 degrees clockwise,
+// which is the icon of the rotated image. Image width and height
+// in ImgSize are swapped. Other icon fields are copied.
+func (icon IconT) Rot90() IconT {
+	dst := icon.remap(func(x, y, size int) Point {
+		return Point{y, size - 1 - x}
+	})
+	dst.ImgSize = Point{icon.ImgSize.Y, icon.ImgSize.X}
+	return dst
+}
+
+// Rot180 returns a copy of the icon rotated by 180 degrees,
+// which is the icon of the rotated image. Other icon fields
+// are copied.
+func (icon IconT) Rot180() IconT {
+	return icon.remap(func(x, y, size int) Point {
+		return Point{size - 1 - x, size - 1 - y}
+	})
+}
+
+// Rot270 returns a copy of the icon rotated by 270 degrees clockwise,
+// which is the icon of the rotated image. Image width and height
+// in ImgSize are swapped. Other icon fields are copied.
+func (icon IconT) Rot270() IconT {
+	dst := icon.remap(func(x, y, size int) Point {
+		return Point{size - 1 - y, x}
+	})
+	dst.ImgSize = Point{icon.ImgSize.Y, icon.ImgSize.X}
+	return dst
+}
+
+// Transformed returns a copy of the icon transformed with t.
+func (icon IconT) Transformed(t Transform) IconT {
+	switch t {
+	case FlipHorizontal:
+		return icon.FlipH()
+	case FlipVertical:
+		return icon.FlipV()
+	case Rotate90:
+		return icon.Rot90()
+	case Rotate180:
+		return icon.Rot180()
+	case Rotate270:
+		return icon.Rot270()
+	}
+	return icon.remap(func(x, y, size int) Point {
+		return Point{x, y}
+	})
+}
+
+// remap returns a copy of the icon, where pixel x, y takes the value
+// of the source icon pixel src(x, y, size).
+func (icon IconT) remap(src func(x, y, size int) Point) IconT {
+	dst := icon
+	if icon.Pixels == nil {
+		return dst
+	}
+	size := icon.size()
+	dst.Pixels = make([]float32, len(icon.Pixels))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			c1, c2, c3 := get(icon, size, src(x, y, size))
+			set(dst, size, Point{x, y}, c1, c2, c3)
+		}
+	}
+	return dst
+}
+
+// mirrors are transformations tried by mirror-invariant functions.
+var mirrors = []Transform{Identity, FlipHorizontal, FlipVertical}
+
+// EucMetricMirrored returns Euclidean distances (see func EucMetric)
+// between iconA and iconB or its mirrored copy, whichever is closest
+// to iconA, together with the transformation of iconB giving the match.
+// Closeness is measured by the sum of distances relative to
+// thresholds of func Similar.
+func EucMetricMirrored(iconA, iconB IconT) (m1, m2, m3 float32,
+	t Transform) {
+	return bestEucMetric(iconA, iconB, mirrors)
+}
+
+// bestEucMetric finds the transformation from ts, which makes
+// iconB closest to iconA.
+func bestEucMetric(iconA, iconB IconT, ts []Transform) (m1, m2, m3 float32,
+	t Transform) {
+	tY, tCbCr := eucThresholds(iconA.size())
+	best := float32(-1)
+	for _, tr := range ts {
+		d1, d2, d3 := EucMetric(iconA, iconB.Transformed(tr))
+		if score := d1/tY + (d2+d3)/tCbCr; best < 0 || score < best {
+			best = score
+			m1, m2, m3, t = d1, d2, d3, tr
+		}
+	}
+	return m1, m2, m3, t
+}
+
+// bestTransform finds the transformation from ts, which makes iconB
+// closest to iconA by the metric of func Similar: DeltaEMetric for
+// Lab icons, and otherwise as func bestEucMetric does.
+func bestTransform(iconA, iconB IconT, ts []Transform) Transform {
+	if iconA.ColorSpace != ColorLab {
+		_, _, _, t := bestEucMetric(iconA, iconB, ts)
+		return t
+	}
+	best, t := float32(-1), Identity
+	for _, tr := range ts {
+		d := DeltaEMetric(iconA, iconB.Transformed(tr))
+		if best < 0 || d < best {
+			best, t = d, tr
+		}
+	}
+	return t
+}
+
+// SimilarMirrored returns the similarity verdict of func Similar
+// for iconA and iconB or mirrored copies of iconB, and the closest
+// transformation of iconB (see func EucMetricMirrored). Closeness
+// of Lab icons is measured by func DeltaEMetric.
+func SimilarMirrored(iconA, iconB IconT) (similar bool, t Transform) {
+	t = bestTransform(iconA, iconB, mirrors)
+	return propSimilar(iconA, iconB) &&
+		eucSimilar(iconA, iconB.Transformed(t)), t
+}
+
+// rotations are transformations tried by rotation-tolerant functions.
+var rotations = []Transform{Identity, Rotate90, Rotate180, Rotate270}
+
+// PropMetricRotated gives image proportion similarity metric as
+// func PropMetric does, but tolerating rotation by 90 degrees,
+// i.e. swapped width and height of image B.
+func PropMetricRotated(iconA, iconB IconT) float64 {
+	m := PropMetric(iconA, iconB)
+	swapped := Point{iconB.ImgSize.Y, iconB.ImgSize.X}
+	if r := propMetric(iconA.ImgSize, swapped); r < m {
+		return r
+	}
+	return m
+}
+
+// SimilarRotated returns the similarity verdict of func Similar for
+// iconA and iconB or copies of iconB rotated by 90, 180 and 270 degrees.
+// It also returns the rotation of iconB closest to iconA among those
+// with similar proportions (see func SimilarMirrored for closeness).
+func SimilarRotated(iconA, iconB IconT) (similar bool, t Transform) {
+	var ts []Transform
+	for _, tr := range rotations {
+		if propSimilar(iconA, iconB.Transformed(tr)) {
+			ts = append(ts, tr)
+		}
+	}
+	if len(ts) == 0 {
+		return false, Identity
+	}
+	t = bestTransform(iconA, iconB, ts)
+	return eucSimilar(iconA, iconB.Transformed(t)), t
+}
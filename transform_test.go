@@ -0,0 +1,218 @@
+package images3
+
+import (
+	"path"
+	"reflect"
+	"testing"
+)
+
+func transformIcons(t *testing.T) (iconA, iconB IconT) {
+	p := path.Join("testdata", "euclidean")
+	imgA, err := Open(path.Join(p, "large.jpg"))
+	if err != nil {
+		t.Fatal("Error opening image:", err)
+	}
+	imgB, err := Open(path.Join(p, "flipped.jpg"))
+	if err != nil {
+		t.Fatal("Error opening image:", err)
+	}
+	return Icon(imgA, "a"), Icon(imgB, "b")
+}
+
+func TestFlip(t *testing.T) {
+	icon := sizedIcon(2)
+	icon.Pixels = []float32{
+		1, 2, 3, 4,
+		5, 6, 7, 8,
+		9, 10, 11, 12}
+	icon.Size = 2
+	icon.ImgSize = Point{3, 5}
+	icon.Path = "p"
+	h := icon.FlipH()
+	if !reflect.DeepEqual(h.Pixels, []float32{
+		2, 1, 4, 3,
+		6, 5, 8, 7,
+		10, 9, 12, 11}) {
+		t.Errorf("Unexpected FlipH pixels %v.", h.Pixels)
+	}
+	v := icon.FlipV()
+	if !reflect.DeepEqual(v.Pixels, []float32{
+		3, 4, 1, 2,
+		7, 8, 5, 6,
+		11, 12, 9, 10}) {
+		t.Errorf("Unexpected FlipV pixels %v.", v.Pixels)
+	}
+	if h.ImgSize != icon.ImgSize || h.Path != icon.Path || h.Size != 2 {
+		t.Error("Icon fields must be copied.")
+	}
+	// The source icon is not changed.
+	if icon.Pixels[0] != 1 {
+		t.Error("Source icon changed.")
+	}
+	if !reflect.DeepEqual(icon.FlipH().FlipH(), icon) ||
+		!reflect.DeepEqual(icon.FlipV().FlipV(), icon) {
+		t.Error("Double flip must give the source icon.")
+	}
+	if EmptyIcon().FlipH().Pixels != nil {
+		t.Error("Flipped empty icon must stay empty.")
+	}
+}
+
+func TestSimilarMirrored(t *testing.T) {
+	iconA, iconB := transformIcons(t)
+	if Similar(iconA, iconB) {
+		t.Error("Expecting non-similarity of mirrored images.")
+	}
+	similar, tr := SimilarMirrored(iconA, iconB)
+	if !similar || tr != FlipHorizontal {
+		t.Errorf("Expected similarity with FlipHorizontal, got %v, %v.",
+			similar, tr)
+	}
+	similar, tr = SimilarMirrored(iconA, iconA.FlipV())
+	if !similar || tr != FlipVertical {
+		t.Errorf("Expected similarity with FlipVertical, got %v, %v.",
+			similar, tr)
+	}
+	similar, tr = SimilarMirrored(iconA, iconA)
+	if !similar || tr != Identity {
+		t.Errorf("Expected similarity with Identity, got %v, %v.",
+			similar, tr)
+	}
+	m1, m2, m3, _ := EucMetricMirrored(iconA, iconB)
+	n1, n2, n3 := EucMetric(iconA, iconB.FlipH())
+	if m1 != n1 || m2 != n2 || m3 != n3 {
+		t.Error("Metrics must be those of the best transformation.")
+	}
+	// Distinct images.
+	img, err := Open(path.Join("testdata", "euclidean", "uniform-green.png"))
+	if err != nil {
+		t.Fatal("Error opening image:", err)
+	}
+	if similar, _ := SimilarMirrored(iconA, Icon(img, "")); similar {
+		t.Error("Expecting non-similarity of distinct images.")
+	}
+}
+
+func TestHashSetMirrored(t *testing.T) {
+	iconA, iconB := transformIcons(t)
+	record := CentralHash(iconA, HyperPoints10, 0.25, 4)
+	contains := func(set []uint64) bool {
+		for _, h := range set {
+			if h == record {
+				return true
+			}
+		}
+		return false
+	}
+	if !contains(HashSetMirrored(iconB, HyperPoints10, 0.25, 4)) {
+		t.Error("Mirrored query must find the record.")
+	}
+	// No duplicates, and the plain hash set is included.
+	set := HashSetMirrored(iconA, HyperPoints10, 0.25, 4)
+	seen := make(map[uint64]bool)
+	for _, h := range set {
+		if seen[h] {
+			t.Errorf("Duplicate hash %v.", h)
+		}
+		seen[h] = true
+	}
+	for _, h := range HashSet(iconA, HyperPoints10, 0.25, 4) {
+		if !seen[h] {
+			t.Errorf("Missing hash %v.", h)
+		}
+	}
+}
+
+func TestRotate(t *testing.T) {
+	icon := sizedIcon(2)
+	icon.Pixels = []float32{
+		1, 2, 3, 4,
+		5, 6, 7, 8,
+		9, 10, 11, 12}
+	icon.Size = 2
+	icon.ImgSize = Point{3, 5}
+	r := icon.Rot90()
+	if !reflect.DeepEqual(r.Pixels, []float32{
+		3, 1, 4, 2,
+		7, 5, 8, 6,
+		11, 9, 12, 10}) {
+		t.Errorf("Unexpected Rot90 pixels %v.", r.Pixels)
+	}
+	if r.ImgSize != (Point{5, 3}) {
+		t.Errorf("Expected swapped image size, got %v.", r.ImgSize)
+	}
+	if !reflect.DeepEqual(icon.Rot90().Rot90(), icon.Rot180()) ||
+		!reflect.DeepEqual(icon.Rot180().Rot90(), icon.Rot270()) ||
+		!reflect.DeepEqual(icon.Rot270().Rot90(), icon) {
+		t.Error("Rotations are inconsistent.")
+	}
+	if icon.Rot180().ImgSize != icon.ImgSize {
+		t.Error("Rot180 must keep image size.")
+	}
+}
+
+func TestPropMetricRotated(t *testing.T) {
+	p := path.Join("testdata", "proportions")
+	icons := make(map[string]IconT)
+	for _, name := range []string{
+		"100x130.png", "130x100.png", "100x122.png", "200x200.png"} {
+		img, err := Open(path.Join(p, name))
+		if err != nil {
+			t.Fatal("Error opening image:", err)
+		}
+		icons[name] = Icon(img, "")
+	}
+	iconA, iconB := icons["100x130.png"], icons["130x100.png"]
+	if PropMetric(iconA, iconB) < thProp {
+		t.Error("Expecting distinct proportions.")
+	}
+	if m := PropMetricRotated(iconA, iconB); m != 0 {
+		t.Errorf("Expected rotated metric 0, got %v.", m)
+	}
+	if PropMetricRotated(iconA, icons["100x122.png"]) < thProp ||
+		PropMetricRotated(iconA, icons["200x200.png"]) < thProp {
+		t.Error("Expecting distinct proportions.")
+	}
+	similar, tr := SimilarRotated(iconA, iconB)
+	if !similar || (tr != Rotate90 && tr != Rotate270) {
+		t.Errorf("Expected similarity with a quarter turn, got %v, %v.",
+			similar, tr)
+	}
+}
+
+// Fixtures with EXIF orientations 3, 6 and 8 store the image rotated.
+func TestSimilarRotated(t *testing.T) {
+	base, err := Open(path.Join("testdata", "euclidean", "large.jpg"))
+	if err != nil {
+		t.Fatal("Error opening image:", err)
+	}
+	iconA := Icon(base, "")
+	for _, tc := range []struct {
+		name string
+		want Transform
+	}{
+		{"1.jpg", Identity},
+		{"3.jpg", Rotate180},
+		{"6.jpg", Rotate90},
+		{"8.jpg", Rotate270},
+	} {
+		img, err := Open(path.Join("testdata", "orientation", tc.name))
+		if err != nil {
+			t.Fatal("Error opening image:", err)
+		}
+		iconB := Icon(img, "")
+		if tc.want != Identity && Similar(iconA, iconB) {
+			t.Errorf("%s: expecting non-similarity.", tc.name)
+		}
+		similar, tr := SimilarRotated(iconA, iconB)
+		if !similar || tr != tc.want {
+			t.Errorf("%s: expected similarity with %v, got %v, %v.",
+				tc.name, tc.want, similar, tr)
+		}
+	}
+	// Mirrored images are not rotated ones.
+	_, iconB := transformIcons(t)
+	if similar, _ := SimilarRotated(iconA, iconB); similar {
+		t.Error("Expecting non-similarity of mirrored images.")
+	}
+}
@@ -0,0 +1,95 @@
+package images3
+
+import "image"
+
+// Default color tolerance of border trimming, in 8-bit units.
+// It tolerates JPEG compression noise in borders.
+const trimTolerance = 12
+
+// TrimBorders finds near-uniform borders of an image, like black
+// bars of video stills (letterboxing) or margins of scans, and returns
+// the image rectangle without them. A border is a sequence of rows
+// (or columns) at an image side, with colors differing from the average
+// color of the outermost row (column) by at most tolerance in each RGB
+// channel (in 8-bit units). Sides are trimmed independently, so that
+// borders can have distinct colors. Images which are uniform as a whole
+// are not trimmed. Crop the image with func IconOfRegion, or use option
+// Trim of func IconWithOptions.
+func TrimBorders(img image.Image, tolerance int) image.Rectangle {
+	b := img.Bounds()
+	if b.Empty() {
+		return b
+	}
+	at := rgbaFunc(img)
+	r := b
+
+	// Top and bottom rows.
+	ref := lineAverage(at, b.Min.X, b.Min.Y, 1, 0, b.Dx())
+	for r.Min.Y < r.Max.Y &&
+		lineUniform(at, r.Min.X, r.Min.Y, 1, 0, r.Dx(), ref, tolerance) {
+		r.Min.Y++
+	}
+	if r.Min.Y == r.Max.Y {
+		return b // Uniform image.
+	}
+	ref = lineAverage(at, b.Min.X, b.Max.Y-1, 1, 0, b.Dx())
+	for r.Max.Y > r.Min.Y &&
+		lineUniform(at, r.Min.X, r.Max.Y-1, 1, 0, r.Dx(), ref, tolerance) {
+		r.Max.Y--
+	}
+
+	// Left and right columns of remaining rows.
+	ref = lineAverage(at, r.Min.X, r.Min.Y, 0, 1, r.Dy())
+	for r.Min.X < r.Max.X &&
+		lineUniform(at, r.Min.X, r.Min.Y, 0, 1, r.Dy(), ref, tolerance) {
+		r.Min.X++
+	}
+	ref = lineAverage(at, r.Max.X-1, r.Min.Y, 0, 1, r.Dy())
+	for r.Max.X > r.Min.X &&
+		lineUniform(at, r.Max.X-1, r.Min.Y, 0, 1, r.Dy(), ref, tolerance) {
+		r.Max.X--
+	}
+	if r.Empty() {
+		return b
+	}
+	return r
+}
+
+// lineAverage returns the average 8-bit RGB color of n pixels
+// starting at x, y with step dx, dy.
+func lineAverage(at func(x, y int) (r, g, b, a uint32),
+	x, y, dx, dy, n int) (avg [3]int) {
+	var sum [3]int
+	for i := 0; i < n; i++ {
+		r, g, b, _ := at(x+i*dx, y+i*dy)
+		sum[0] += int(r >> 8)
+		sum[1] += int(g >> 8)
+		sum[2] += int(b >> 8)
+	}
+	for c := range sum {
+		avg[c] = sum[c] / n
+	}
+	return avg
+}
+
+// lineUniform tells whether n pixels starting at x, y with step
+// dx, dy differ from color ref by at most tolerance.
+func lineUniform(at func(x, y int) (r, g, b, a uint32),
+	x, y, dx, dy, n int, ref [3]int, tolerance int) bool {
+	for i := 0; i < n; i++ {
+		r, g, b, _ := at(x+i*dx, y+i*dy)
+		if abs(int(r>>8)-ref[0]) > tolerance ||
+			abs(int(g>>8)-ref[1]) > tolerance ||
+			abs(int(b>>8)-ref[2]) > tolerance {
+			return false
+		}
+	}
+	return true
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
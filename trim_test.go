@@ -0,0 +1,91 @@
+package images3
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"path"
+	"testing"
+)
+
+// framed places an image on a canvas of color c at offset off,
+// passing the result through JPEG compression.
+func framed(t *testing.T, img image.Image, canvas image.Rectangle,
+	off image.Point, c color.Color) image.Image {
+	dst := image.NewRGBA(canvas)
+	draw.Draw(dst, canvas, image.NewUniform(c), image.Point{}, draw.Src)
+	draw.Draw(dst, img.Bounds().Sub(img.Bounds().Min).Add(off),
+		img, img.Bounds().Min, draw.Src)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85}); err != nil {
+		t.Fatal("Error encoding image:", err)
+	}
+	out, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal("Error decoding image:", err)
+	}
+	return out
+}
+
+func near(a, b image.Rectangle, d int) bool {
+	return abs(a.Min.X-b.Min.X) <= d && abs(a.Min.Y-b.Min.Y) <= d &&
+		abs(a.Max.X-b.Max.X) <= d && abs(a.Max.Y-b.Max.Y) <= d
+}
+
+func TestTrimBorders(t *testing.T) {
+	img, err := Open(path.Join("testdata", "euclidean", "large.jpg"))
+	if err != nil {
+		t.Fatal("Error opening image:", err)
+	}
+	base := Icon(img, "")
+
+	for _, tc := range []struct {
+		name   string
+		canvas image.Rectangle
+		off    image.Point
+		c      color.Color
+	}{
+		{"letterbox", image.Rect(0, 0, 533, 600), image.Pt(0, 100), color.Black},
+		{"pillarbox", image.Rect(0, 0, 800, 400), image.Pt(133, 0), color.Black},
+		{"margins", image.Rect(0, 0, 600, 480), image.Pt(40, 50), color.White},
+	} {
+		framedImg := framed(t, img, tc.canvas, tc.off, tc.c)
+		want := image.Rect(0, 0, 533, 400).Add(tc.off)
+		// Compression artifacts spread within 8x8 pixel blocks.
+		if got := TrimBorders(framedImg, trimTolerance); !near(got, want, 8) {
+			t.Errorf("%s: expected rectangle %v, got %v.", tc.name, want, got)
+		}
+		if Similar(Icon(framedImg, ""), base) {
+			t.Errorf("%s: expected non-similarity without trimming.", tc.name)
+		}
+		icon := IconWithOptions(framedImg, "", IconOptions{Trim: true})
+		if !near(icon.Trimmed, want, 8) {
+			t.Errorf("%s: expected trimmed rectangle %v, got %v.",
+				tc.name, want, icon.Trimmed)
+		}
+		if icon.ImgSize != (Point{icon.Trimmed.Dx(), icon.Trimmed.Dy()}) {
+			t.Errorf("%s: image size %v mismatches trimmed rectangle %v.",
+				tc.name, icon.ImgSize, icon.Trimmed)
+		}
+		if !Similar(icon, base) {
+			t.Errorf("%s: expected similarity with trimming.", tc.name)
+		}
+	}
+
+	// Images with no borders are not changed.
+	if got := TrimBorders(img, trimTolerance); got != img.Bounds() {
+		t.Errorf("Expected no trimming, got %v.", got)
+	}
+	icon := IconWithOptions(img, "", IconOptions{Trim: true})
+	if icon.ImgSize != base.ImgSize {
+		t.Errorf("Expected image size %v, got %v.", base.ImgSize, icon.ImgSize)
+	}
+	// Uniform images are not trimmed.
+	img = &image.Gray{Pix: make([]uint8, 100), Stride: 10,
+		Rect: image.Rect(5, 5, 15, 15)}
+	if got := TrimBorders(img, 0); got != img.Bounds() {
+		t.Errorf("Expected no trimming, got %v.", got)
+	}
+}